@@ -32,8 +32,8 @@ As a test the
 svgo_test.sh bash script can be run to generate the different examples
 with the generated float64 version of svgo (svgofloat).
 
-The configuration file svgo.json contains a patch which adds a method
-(SetFloatDecimals) to svgo to specify the float decimal precision
+The configuration file svgo.json contains patch operations which add a
+method (SetFloatDecimals) to svgo to specify the float decimal precision
 (default is 2), which can be changed on the fly:
 
   width := 500.0
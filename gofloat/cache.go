@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// configHash returns the hash of the effective configuration of a
+// repository. It includes the version of gofloat and the flags which
+// change the output.
+func configHash(cfg Config, repo Repository) string {
+	buf, _ := json.Marshal(repo) // a Repository can always be marshaled
+	flags := fmt.Sprintf("%s\x00harness=%t golden=%t examples=%t "+
+		"verify=%t\x00", version, *harness, *goldenRun, *examples,
+		*verifyPkg)
+	return hash(append([]byte(cfg.Hash+"\x00"+flags), buf...))
+}
+
+// cached checks if the output in toDir is still valid: the source
+// package and the configuration did not change since the last
+// generation, no generated file is missing and no merge conflicts
+// were left.
+func cached(fromDir, toDir string, cfg Config, repo Repository) (bool,
+	error) {
+	m, err := readManifest(toDir)
+	if err != nil || m.Source.Hash == "" || m.Conflicts > 0 {
+		return false, err
+	}
+	if m.Config != configHash(cfg, repo) {
+		return false, nil
+	}
+	src, err := readSource(fromDir)
+	if err != nil {
+		return false, err
+	}
+	if src.Hash != m.Source.Hash {
+		return false, nil
+	}
+	for name := range m.Files {
+		if _, err := os.Stat(filepath.Join(toDir, name)); err != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
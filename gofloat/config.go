@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"go/build"
 	"os"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/stanim/typewriter/packages"
 )
@@ -11,30 +14,116 @@ import (
 // Repository refers to a destination repository, which contains the
 // automatically translated code..
 type Repository struct {
-	Name     string // repo name:  svgof, svgo2f
-	ToType   string // "float64"
+	Name     string            // repo name:  svgof, svgo2f
+	ToType   string            // "float64"
+	Types    map[string]string // several types: {"int32": "float32"}
+	Alias    string            // optional alias for ToType: "Number"
+	Rounding string            // float to int: "truncate", "round" or "floor"
+	Scale    int64             // float to fixed-point: 1000
 	Disabled bool
 	Recurse  bool // also convert subfolders?
 }
 
+// typeMap returns the type map of the repository. Without Types,
+// fromType is converted to ToType.
+func (repo Repository) typeMap(fromType string) packages.TypeMap {
+	if len(repo.Types) == 0 {
+		return packages.TypeMap{fromType: repo.ToType}
+	}
+	return packages.TypeMap(repo.Types)
+}
+
+// Build is a build variant. The go files which match its build
+// constraints are converted and type checked together. Empty fields
+// are taken from the go environment.
+type Build struct {
+	GOOS   string   // "linux"
+	GOARCH string   // "amd64"
+	Tags   []string // build tags: ["appengine"]
+}
+
+// context returns the build context of the variant.
+func (b Build) context() *build.Context {
+	ctxt := build.Default
+	if b.GOOS != "" {
+		ctxt.GOOS = b.GOOS
+	}
+	if b.GOARCH != "" {
+		ctxt.GOARCH = b.GOARCH
+	}
+	ctxt.BuildTags = b.Tags
+	return &ctxt
+}
+
+// String implements the fmt.Stringer interface.
+// (For example "linux/amd64 appengine".)
+func (b Build) String() string {
+	ctxt := b.context()
+	return strings.TrimSpace(ctxt.GOOS + "/" + ctxt.GOARCH + " " +
+		strings.Join(b.Tags, ","))
+}
+
+// label returns the suffix of the log messages of a build, which is
+// empty if there is only one build.
+func (b Build) label(builds []Build) string {
+	if len(builds) < 2 {
+		return ""
+	}
+	return " for " + b.String()
+}
+
 // Patch uses basically strings.Replace to apply patches to a file.
+// A patch fails if it does not replace Old exactly Count times (or at
+// least once without Count). Instead a patch can be the path of a
+// unified diff file (relative to the config file), which is applied
+// after the header and footer are added.
 type Patch struct {
 	Old   string
 	New   string
-	N     int
-	Start int
+	N     int    // maximum number of replacements
+	Start int    // byte offset
+	Count int    // expected number of replacements
+	Diff  string // path of a unified diff file
+
+	diffs   []packages.FileDiff // parsed by Open
+	missing bool                // diff file
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. A string is
+// the path of a diff file.
+func (p *Patch) UnmarshalJSON(buf []byte) error {
+	if err := json.Unmarshal(buf, &p.Diff); err == nil {
+		return nil
+	}
+	type plain Patch // without this method
+	return json.Unmarshal(buf, (*plain)(p))
+}
+
+// sortedOps returns the sorted filenames of patch operations.
+func sortedOps(ops map[string][]packages.Op) []string {
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // Config can be applied to multiple destination repositories.
 type Config struct {
-	Footer       map[string][]byte
+	Args         map[string][]string           // of main packages by folder name
+	Builds       []Build                       // build variants
+	Footer       map[string]*template.Template // by filename
 	FormatVar    packages.Set
 	FormatFunc   map[string]string
 	From         string
 	FromType     string
-	Header       []byte
+	Hash         string // of the configuration data
+	Header       *template.Template
 	LogConflicts bool
-	Patches      map[string][]Patch // patches by filename
+	Ops          map[string][]packages.Op      // patch operations by filename
+	Operators    map[string]packages.Operators // by ToType
+	Patches      map[string][]Patch            // patches by filename
 	Printf       map[string]int
 	ReadMe       []byte
 	Skip         packages.Skip
@@ -42,6 +131,8 @@ type Config struct {
 }
 
 type configData struct {
+	Args         map[string][]string
+	Builds       []Build
 	Footer       map[string][]string
 	FormatVar    []string // allow non-constant format in call to FormatFunc
 	FormatFunc   map[string]string
@@ -49,6 +140,8 @@ type configData struct {
 	FromType     string
 	Header       []string
 	LogConflicts bool
+	Ops          map[string][]packages.Op
+	Operators    map[string]packages.Operators
 	Patches      map[string][]Patch
 	Printf       map[string]int
 	ReadMe       []string
@@ -75,6 +168,15 @@ func Open(path string) ([]Repository, Config, error) {
 		return nil, cfg, context(err)
 	}
 	cfgd := d.Config
+	buf, err := json.Marshal(cfgd)
+	if err != nil {
+		return nil, cfg, context(err)
+	}
+	diffs, err := readDiffs(path, cfgd.Patches)
+	if err != nil {
+		return nil, cfg, err
+	}
+	cfg.Hash = hash(append(buf, diffs...))
 	if cfgd.From == "" {
 		return nil, cfg, contextErr("config 'From' repo is unknown")
 	}
@@ -85,6 +187,17 @@ func Open(path string) ([]Repository, Config, error) {
 		if d.Repos[i].ToType == "" {
 			d.Repos[i].ToType = "float64"
 		}
+		switch d.Repos[i].Rounding {
+		case "", packages.Truncate, packages.Round, packages.Floor:
+		default:
+			return nil, cfg, contextErr("repo %q: unknown rounding %q",
+				d.Repos[i].Name, d.Repos[i].Rounding)
+		}
+	}
+	cfg.Args = cfgd.Args
+	cfg.Builds = cfgd.Builds
+	if len(cfg.Builds) == 0 {
+		cfg.Builds = []Build{{}} // the go environment
 	}
 	cfg.FormatVar = map[string]struct{}{}
 	for _, name := range cfgd.FormatVar {
@@ -97,14 +210,23 @@ func Open(path string) ([]Repository, Config, error) {
 	} else {
 		cfg.FromType = cfgd.FromType
 	}
-	cfg.Footer = map[string][]byte{}
+	cfg.Footer = map[string]*template.Template{}
 	for fn, lines := range cfgd.Footer {
-		cfg.Footer[fn] = []byte(
+		cfg.Footer[fn], err = template.New(fn).Parse(
 			"\n// Automatically appended by gofloat\n\n" +
 				strings.Join(lines, "\n") + "\n")
+		if err != nil {
+			return nil, cfg, contextErr("footer of %q: %s", fn, err)
+		}
+	}
+	cfg.Header, err = template.New("header").Parse(
+		strings.Join(cfgd.Header, "\n"))
+	if err != nil {
+		return nil, cfg, contextErr("header: %s", err)
 	}
-	cfg.Header = []byte(strings.Join(cfgd.Header, "\n"))
 	cfg.LogConflicts = cfgd.LogConflicts
+	cfg.Ops = cfgd.Ops
+	cfg.Operators = cfgd.Operators
 	cfg.Patches = cfgd.Patches
 	if len(cfgd.Printf) == 0 {
 		cfg.Printf = map[string]int{
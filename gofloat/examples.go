@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// exampleFailRe matches the first line of a failed example in the
+// output of go test.
+var exampleFailRe = regexp.MustCompile(`^--- FAIL: (Example\w*) \(`)
+
+// exampleOutputs runs the examples of the package in dirname as
+// importPath and returns the actual output of the failed examples by
+// name.
+func exampleOutputs(dirname, importPath string) (map[string]string,
+	error) {
+	cmd, remove, err := goCommand(dirname, importPath, "test", "-run",
+		"^Example", ".")
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	out, err := cmd.CombinedOutput()
+	outputs := parseExamples(out)
+	if err != nil && len(outputs) == 0 {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, context(err)
+		}
+		return nil, contextErr("go test %q:\n%s", importPath, out)
+	}
+	return outputs, nil
+}
+
+// parseExamples returns the output ("got:") of the failed examples in
+// the output of go test:
+//
+//	--- FAIL: ExampleHalf (0.00s)
+//	got:
+//	0.5
+//	want:
+//	0
+func parseExamples(out []byte) map[string]string {
+	outputs := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	name := ""
+	var got []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case name == "":
+			if m := exampleFailRe.FindStringSubmatch(line); m != nil &&
+				scanner.Scan() && scanner.Text() == "got:" {
+				name, got = m[1], nil
+			}
+		case line == "want:":
+			outputs[name] = strings.Join(got, "\n")
+			name = ""
+		default:
+			got = append(got, line)
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/stanim/typewriter/packages"
+)
+
+// goldenName is the name of the golden file with the output of the
+// original main package (see the -golden flag).
+const goldenName = "gofloat.golden"
+
+// goldenTimeout limits the run time of a main package.
+const goldenTimeout = 30 * time.Second
+
+// isMain checks if the go files in dirname, which match the build
+// constraints, are a main package (eg not a generator, which is
+// ignored by the build).
+func isMain(dirname string) (bool, error) {
+	bp, err := build.ImportDir(dirname, 0)
+	if _, ok := err.(*build.NoGoError); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, context(err)
+	}
+	return bp.Name == "main", nil
+}
+
+// golden runs the original main package in fromDir and the converted
+// one in toDir with the same arguments and saves the output of the
+// original as golden file in toDir. It returns the unified diff of
+// both outputs after normalizing their numbers.
+func golden(fromDir, fromRepo, toDir, toRepo string, args []string) (
+	string, error) {
+	want, err := runMain(fromDir, fromRepo, args)
+	if err != nil {
+		return "", err
+	}
+	got, err := runMain(toDir, toRepo, args)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(toDir, goldenName), want,
+		0666); err != nil {
+		return "", context(err)
+	}
+	return packages.UnifiedDiff("original", "converted",
+		packages.Normalize(want), packages.Normalize(got)), nil
+}
+
+// runMain builds the main package in dirname as importPath (see
+// goCommand) and runs it with args in an empty temporary folder (so
+// that files it writes do not end up in the source or destination).
+// It returns the standard output.
+func runMain(dirname, importPath string, args []string) ([]byte, error) {
+	tmp, err := ioutil.TempDir("", "gofloat-run-")
+	if err != nil {
+		return nil, context(err)
+	}
+	defer os.RemoveAll(tmp)
+	exe := filepath.Join(tmp, "main")
+	build, remove, err := goCommand(dirname, importPath, "build", "-o",
+		exe, ".")
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	if out, err := build.CombinedOutput(); err != nil {
+		return nil, contextErr("go build %q: %s\n%s", dirname, err, out)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = tmp
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, context(err)
+	}
+	timer := time.AfterFunc(goldenTimeout, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	if !timer.Stop() {
+		return nil, contextErr("%q did not finish within %s", dirname,
+			goldenTimeout)
+	}
+	if err != nil {
+		return nil, contextErr("run %q: %s\n%s", dirname, err,
+			stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
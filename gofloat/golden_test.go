@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsMain(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{"main", map[string]string{
+			"main.go": "package main\n\nfunc main() {}\n"}, true},
+		{"ignored generator", map[string]string{
+			"gen.go": "//go:build ignore\n\npackage main\n\n" +
+				"func main() {}\n",
+			"v.go": "package v\n"}, false},
+		{"no go files", map[string]string{"README": "v\n"}, false},
+	}
+	for _, test := range tests {
+		dirname := writePackage(t, test.files)
+		got, err := isMain(dirname)
+		os.RemoveAll(dirname)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// version is the version of gofloat, which is available in headers
+// and footers as {{.Version}}.
+const version = "1.0.0"
+
+// headerData are the variables of the header and footer templates.
+type headerData struct {
+	Source   string // import path of the source package
+	Revision string // git commit of the source (if any)
+	Version  string // of gofloat
+	Date     string // of the generation: "2006-01-02"
+}
+
+// newHeaderData returns the template variables of a source package.
+func newHeaderData(fromDir, fromRepo string) headerData {
+	return headerData{
+		Source:   fromRepo,
+		Revision: gitCommit(fromDir),
+		Version:  version,
+		Date:     time.Now().Format("2006-01-02"),
+	}
+}
+
+// execute executes a template with data. A nil template gives an
+// empty string.
+func execute(tmpl *template.Template, data headerData) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", context(err)
+	}
+	return buf.String(), nil
+}
+
+// decorate adds the header, the generated code marker and the footer
+// to the go files in dirname. Unknown files are skipped.
+func decorate(dirname string, data headerData, header *template.Template,
+	footer map[string]*template.Template, unknown map[string]string) error {
+	top, err := execute(header, data)
+	if err != nil {
+		return err
+	}
+	top = strings.TrimRight(top, "\n")
+	if top != "" {
+		top += "\n\n"
+	}
+	top += "// Code generated by gofloat from " + data.Source +
+		". DO NOT EDIT.\n\n"
+	filenames, err := filepath.Glob(filepath.Join(dirname, "*.go"))
+	if err != nil {
+		return context(err)
+	}
+	for _, filename := range filenames {
+		base := filepath.Base(filename)
+		if _, ok := unknown[base]; ok {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return context(err)
+		}
+		bottom, err := execute(footer[base], data)
+		if err != nil {
+			return err
+		}
+		buf = append(insertHeader(buf, top), bottom...)
+		if err := ioutil.WriteFile(filename, buf, 0666); err != nil {
+			return context(err)
+		}
+	}
+	return nil
+}
+
+// insertHeader inserts the header into the source of a go file. Build
+// constraints ("//go:build" or "// +build") have to stay in front of
+// it, so it is inserted after them. The header ends with an empty
+// line, so that it does not become part of the package documentation.
+func insertHeader(src []byte, header string) []byte {
+	pos, offset := 0, 0
+	for _, line := range strings.SplitAfter(string(src), "\n") {
+		text := strings.TrimSpace(line)
+		if text != "" && !strings.HasPrefix(text, "//") {
+			break // package clause or block comment
+		}
+		offset += len(line)
+		if strings.HasPrefix(text, "//go:build") ||
+			strings.HasPrefix(text, "// +build") {
+			pos = offset
+		}
+	}
+	if pos > 0 {
+		// keep the empty line after the build constraints
+		for pos < len(src) && (src[pos] == '\n' || src[pos] == '\r') {
+			pos++
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(src[:pos])
+	buf.WriteString(header)
+	buf.Write(src[pos:])
+	return buf.Bytes()
+}
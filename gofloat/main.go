@@ -16,18 +16,19 @@ ints to floats:
   $ gofloat svgo.json
   Open "svgo.json" ...
   github.com/ajstarks/svgo -> github.com/stanim/svgotest:
-  - Empty "github.com/stanim/svgotest" ...
-  - Convert type from "int" to "float64" ...
+  - Clean "github.com/stanim/svgotest" ...
+  - Convert types (int -> float64) ...
   - Fix type conflicts ...
 	... no type conflicts found.
+  - Apply patch operations to "github.com/stanim/svgotest" ...
+	... applied 8 operations.
   - Format "github.com/stanim/svgotest" ...
-  - Applied 13 patches to "github.com/stanim/svgotest" ...
   - Copy non-go files of "github.com/stanim/svgotest" ...
   - OK
   ...
   github.com/ajstarks/svgo/imfade -> github.com/stanim/svgotest/imfade:
-  - Empty "github.com/stanim/svgotest/imfade" ...
-  - Convert type from "int" to "float64" ...
+  - Clean "github.com/stanim/svgotest/imfade" ...
+  - Convert types (int -> float64) ...
   - Fix type conflicts ...
 	+ /home/stani/Labo/go/src/github.com/stanim/svgotest/imfade/imfade.go:25:14: cannot compare i < width - 128 (mismatched types int and float64)
 	+ /home/stani/Labo/go/src/github.com/stanim/svgotest/imfade/imfade.go:26:16: cannot pass argument i (variable of type int) to parameter of type float64
@@ -37,8 +38,8 @@ ints to floats:
   - OK
   ...
   github.com/ajstarks/svgo/planets -> github.com/stanim/svgotest/planets:
-  - Empty "github.com/stanim/svgotest/planets" ...
-  - Convert type from "int" to "float64" ...
+  - Clean "github.com/stanim/svgotest/planets" ...
+  - Convert types (int -> float64) ...
   - Fix type conflicts ...
 	... no type conflicts found.
   - Format "github.com/stanim/svgotest/planets" ...
@@ -46,22 +47,251 @@ ints to floats:
   - SKIP
   ...
 
+With the -n (or -diff) flag, gofloat converts into a temporary folder
+and prints a unified diff against the destination instead of writing
+it. The log is printed to stderr. The exit status is 1 if anything
+would change, 0 if not and 2 on errors:
+
+  $ gofloat -n svgo.json > svgo.diff
+
 Output
 
 If a package is succesfully converted it will finish with an 'OK'.
+Comments which are lost or moved to another declaration during the
+conversion are reported with a '?' (see TestLostComments of the
+packages package for a test).
+Only the lines which are changed by the conversion differ from the
+original source, the rest of the code keeps its original layout.
+
+The generated files are listed with their hash in a manifest
+(".gofloat.json") in the destination folder. Before converting only
+these files are removed. Other (hand-written) files are kept, but
+gofloat refuses to overwrite them, unless the -f flag is given.
+
+Pristine copies of the generated files are kept in the ".gofloat"
+folder. If a generated file was edited manually (eg to fix a SKIP),
+the edits are merged with the new generated file (a three-way merge
+with the pristine copy). Conflicts are reported and marked in the
+file:
+
+  - Merge manual edits of "github.com/stanim/svgotest" ...
+	! 1 merge conflicts in "svg.go"
+
+  <<<<<<< manual
+  ...
+  =======
+  ...
+  >>>>>>> generated
+
+The files with conflicts are written, but the package fails (with
+the number of conflicts in the summary) until all markers are
+resolved. With the -f flag manual edits are overwritten.
+
+The manifest also records a hash of the configuration, the version
+of gofloat and the flags which change the output (-harness, -golden,
+-examples and -verify). A package is only converted again if its
+source files or any of these changed or if generated files are
+missing, otherwise it finishes with 'OK (unchanged)'. The -nocache
+flag converts all packages.
+
+Every package is converted in a hidden staging folder next to its
+destination. Only if all phases succeed, the files are moved into
+the destination, otherwise (also for a SKIP) the previous output is
+kept.
+
+With the -j flag, several packages are converted in parallel:
+
+  $ gofloat -j 4 svgo.json
+
+The log of every package is buffered and printed in order, followed
+by a summary. A package which fails does not stop the others:
+
+  Summary:
+    OK    github.com/ajstarks/svgo -> github.com/stanim/svgotest
+    OK    github.com/ajstarks/svgo/imfade -> github.com/stanim/svgotest/imfade
+    SKIP  github.com/ajstarks/svgo/planets -> github.com/stanim/svgotest/planets
+    2 OK, 1 SKIP, 0 FAIL
+
+Header and footer
+
+Every generated go file starts with the "Header" of the configuration
+and the standard marker of generated code. A "Footer" is appended to
+the files it is configured for. Both are templates (text/template)
+with the variables .Source (import path of the source package),
+.Revision (its git commit), .Version (of gofloat) and .Date:
+
+  "Header": ["// Port of {{.Source}} ({{.Revision}}) with float coordinates.", ""]
+
+gives:
+
+  // Port of github.com/ajstarks/svgo (f400c01...) with float coordinates.
+
+  // Code generated by gofloat from github.com/ajstarks/svgo. DO NOT EDIT.
+
+  // Package svg generates SVG as defined by the Scalable Vector Graphics 1.1 Specification
+  package svg
+
+The header is inserted after build constraints and is separated from
+the package documentation by an empty line. (As .Date changes every
+day, it also changes the generated files.)
+
+Patches
+
+The "Patches" replace text in the generated files (by filename) in
+phase 4. Every patch has to replace its "Old" text at least once, or
+exactly "Count" times. Otherwise the package fails with the nearest
+match, so that a patch does not silently stop working when the
+source changes upstream:
+
+  - Apply 2 patches to "github.com/stanim/svgotest" ...
+	! svg.go #2: 0 replacements of "return &SVG{w} }", expected at least 1
+	  nearest match svg.go:64: func New(w io.Writer) *SVG { return &SVG{Writer: w} }
+
+The patches command converts into a temporary folder and lists the
+replacements of every patch without writing anything. With -check it
+fails on stale patches:
+
+  $ gofloat patches -check svgo.json
+
+A patch can also be the path of a unified diff file (relative to the
+config file), which is applied after the header and footer are
+added:
+
+  "Patches": {"svg.go": ["svgo/svg.go.diff"]}
+
+Like GNU patch, a hunk still applies if its lines moved (offset) or
+if up to 2 lines of its context changed (fuzz), which is reported
+with a '?'. The package fails if the diff file is missing, if none
+of its hunks apply or if any hunk is rejected. The rejected hunks
+are written to a reject file (eg "svgo/svg.go.diff.rej"), which is
+removed again once all hunks apply. (With -n and the patches command
+no reject files are written.) The diff files can be regenerated from
+manual edits of the destination files:
+
+  $ gofloat patches -update svgo.json
+
+This writes the difference between the generated source (without
+diff files) and the destination file to its first diff file.
+
+Patch operations
+
+Besides the text "Patches", which are applied to the saved files in
+phase 4, "Ops" are applied to the syntax tree of the converted
+package with type information before phase 3 (so that the format
+function can be a method they add). They do not depend on the layout
+of the source, so they survive upstream reformatting:
+
+  "Ops": {"svg.go": [
+    {"Op": "AddField", "Name": "SVG", "Code": "FloatVerb string",
+     "Value": "\"%.2f\""},
+    {"Op": "ToMethod", "Name": "translate", "Recv": "svg *SVG"},
+    {"Op": "AddMethod", "Code": "func (svg *SVG) f(x float64) string {...}",
+     "Imports": ["fmt"]},
+    {"Op": "Rename", "Name": "SVG.Writer", "To": "W"},
+    {"Op": "Insert", "Name": "New", "Code": "log.Println(w)", "At": "end"}]}
+
+AddField adds keys to composite literals of the struct and
+initializes the field with "Value" (&SVG{w} becomes &SVG{Writer: w,
+FloatVerb: "%.2f"}). ToMethod turns translate(x, y) into
+svg.translate(x, y), also in the function itself. It fails if the
+function is used outside the methods of the receiver type, so
+functions which call each other are converted from the caller on.
+An operation fails as well if it leaves type errors. See packages.Op
+for all fields. Operations which were already applied are skipped.
+
+Tests
+
+Test files are converted like the other files, including their test
+tables. An external test package (eg "svgo_test") imports the
+converted package and is type checked against its converted
+declarations in memory, so that its type conflicts are fixed as
+well. Tests and examples of -verify and -examples run against the
+staged package.
+
+Expected outputs of examples often reflect int formatting. With the
+-examples flag, the examples are run after the conversion and the
+"// Output:" comments of the failing ones are replaced by their
+actual output:
+
+  - Rewrite example output of "github.com/stanim/svgotest" ...
+	... 2 examples rewritten.
+
+Differential testing
+
+With the -harness flag, gofloat generates a fuzz test in every
+converted package ("gofloat_diff_test.go"), which calls the original
+and the converted version of every exported function with integer
+parameters with the same fuzzed arguments and reports any difference
+of their results or panics. Methods are called on a value of a
+constructor with an io.Writer (eg svgo.New(w).Circle(x, y, r)), so
+that for svgo the emitted SVG text is compared as well:
+
+  $ cd $GOPATH/src/github.com/stanim/svgotest
+  $ go test -fuzz FuzzDiffSVGCircle
+  --- FAIL: FuzzDiffSVGCircle (0.00s)
+	gofloat_diff_test.go:42: SVG.Circle(1, 2, 3):
+	original:  "<circle cx=\"1\" ..."
+	converted: "<circle cx=\"1.000000\" ..."
+
+Without -fuzz, go test (and -verify) only runs the seed arguments.
+
+With the -golden flag, every converted main package (eg imfade or
+planets) and its original are built and run with the same arguments
+in an empty temporary folder. The arguments are configured by folder
+name:
+
+  "Args": {"planets": ["-w", "800"]}
+
+The standard output of the original is saved as golden file
+("gofloat.golden") and compared with the converted output. Numbers
+are normalized first, so that "5" and "5.00" are equal:
+
+  - Compare output of "github.com/stanim/svgotest/imfade" ...
+	... output differs:
+	--- original
+	+++ converted
+	@@ -3 +3 @@
+	-<rect x="0" y="0" width="128" height="128"/>
+	+<rect x="0" y="0" width="127.5" height="128"/>
+
+Status
+
+The manifest also records the state of the source package: the hash
+of its files, its git commit (if it is a git checkout) and its
+exported declarations. The status command reports which source
+packages changed since their last generation and which exported
+declarations were added (+) or removed (-):
+
+  $ gofloat status svgo.json
+  Open "svgo.json" ...
+  github.com/ajstarks/svgo -> github.com/stanim/svgotest: changed (f400c01 -> 56dc8f0)
+	+ func (*SVG) Path
+  github.com/ajstarks/svgo/imfade -> github.com/stanim/svgotest/imfade: up to date
+
+The exit status is 1 if any source package changed.
+
 Process
 
-It uses 4 phases:
+It uses 6 phases:
 
 1) Convert types: all int types are converted to floats.
 
 2) Fix all type conflicts:
 for example make sure that all slice indices are integers.
+Afterwards redundant conversions (eg int(i) or float64(5.0)) are
+removed.
 
 3) Fix format arguments in printf functions. ("%d" becomes "%f")
 
 4) Apply patches and add header/footer if necessary.
 
+5) Copy the non-go files.
+
+6) Verify (only with the -verify flag): build, vet and test the
+converted package in its staging folder with the go tool. If any of
+them fails, its output is reported and the package fails (so the
+previous output is kept).
+
 See the package 'packages' for more information.
 
 Limitations
@@ -71,8 +301,8 @@ in call to printf functions. These packages will be skipped and should
 be manually fixed first. Let's take svgo planets as an example:
 
   github.com/ajstarks/svgo/planets -> github.com/stanim/svgotest/planets:
-  - Empty "github.com/stanim/svgotest/planets" ...
-  - Convert type from "int" to "float64" ...
+  - Clean "github.com/stanim/svgotest/planets" ...
+  - Convert types (int -> float64) ...
   - Fix type conflicts ...
 	... no type conflicts found.
   - Format "github.com/stanim/svgotest/planets" ...
@@ -111,12 +341,94 @@ to '%f' (float), it is safe to ignore this issue. By adding the
 filename to the FormatVar list in the json configuration file, gofloat
 will not perform this check.
 
-2) Converting to float32 is not supported.
+2) strconv.Atoi is converted to strconv.ParseFloat, which returns a
+float64. For float32 this gives a conversion of both its results,
+which is reported as an unfixed type conflict and needs to be fixed
+by hand:
+
+  f, err := strconv.ParseFloat(s, 32)
+  x := float32(f)
+
+Integers
+
+Integer types can be widened as well, for example from "int" to
+"int64" or "uint". The int results of len() are converted.
+Negative constants assigned to unsigned types are reported as an
+error, as converting them at run time would change their value
+silently: the source has to be changed or skipped.
+
+Alias
+
+If a repository has an "Alias" (for example "Number"), the converted
+code uses the alias instead of the literal type, which is declared
+once in a generated file (for example "number.go"):
+
+  type Number = float64
+
+This way the numeric type can be changed in one place.
+
+Floats to integers
+
+The conversion can also be reversed, for example from "float64" to
+"int" (FromType and ToType) for embedded targets without FPU. The
+"Rounding" of a repository ("truncate", "round" or "floor") is used
+to quantize float literals (2.5 -> 2) and to convert floats to ints
+(int(math.Round(x))). Math functions which do not change integers,
+such as math.Floor, are removed. With a "Scale" (eg 1000) the
+target is a fixed-point type: constants and converted floats are
+multiplied by the scale (x + 1 -> x + 1000) and products and
+quotients are rescaled (x * y / 1000 and x * 1000 / y). Every site
+where precision is lost, including every rescaled product or
+quotient, is reported:
+
+  - Fix type conflicts ...
+	... lost precision at 2 sites:
+	! imfade.go:12:7: 2.5 (quantized to 3)
+	! imfade.go:20:9: math.Sqrt(float64(y)) (float64 converted to int)
+
+Types
+
+Instead of a single "ToType", a repository can map several source
+types at once with "Types", each with its own rules:
+
+  "Repos": [{"Name": "svgof",
+             "Types": {"int": "float64", "int32": "float32"}}]
+
+Build constraints
+
+Only the go files which match the build constraints of the go
+environment are converted and type checked together. Other build
+variants are configured with "Builds":
+
+  "Builds": [{"GOOS": "linux"}, {"GOOS": "windows"},
+             {"GOOS": "linux", "Tags": ["appengine"]}]
+
+The files of every variant are converted and their type conflicts
+are fixed in turn. Files which match no variant (eg a generator with
+"//go:build ignore") are copied unchanged.
+
+Operators
+
+The "ToType" of a repository can also be a non-primitive type, such
+as "*big.Float". As arithmetic operators are not defined on these
+types, they are rewritten into method calls according to the
+"Operators" table of the configuration, for example:
+
+  "Operators": {"*big.Float": {
+      "Binary": {"+": "new(big.Float).Add(X, Y)",
+                 "<": "X.Cmp(Y) < 0"},
+      "Unary": {"-": "new(big.Float).Neg(X)"},
+      "Literal": "big.NewFloat(V)",
+      "Imports": ["math/big"]}}
+
+X and Y are replaced by the operands and V by a constant. So "a += 1"
+becomes "a = new(big.Float).Add(a, big.NewFloat(1))".
 */
 package main
 
 //TODO: check do the visitors need all their fields
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -128,114 +440,413 @@ import (
 	"github.com/stanim/typewriter/packages"
 )
 
-const maxInt = int(^uint(0) >> 1)
-
 var (
 	verbose   = flag.Bool("v", false, "verbose")
+	dryRun    = flag.Bool("n", false, "print a diff instead of writing files")
+	force     = flag.Bool("f", false, "overwrite modified or unknown files")
+	noCache   = flag.Bool("nocache", false, "convert unchanged packages as well")
+	jobs      = flag.Int("j", 1, "number of packages converted in parallel")
+	verifyPkg = flag.Bool("verify", false, "build, vet and test the output")
+	harness   = flag.Bool("harness", false, "generate a differential fuzz test")
+	goldenRun = flag.Bool("golden", false, "compare the output of main packages")
+	examples  = flag.Bool("examples", false, "rewrite the output of examples")
+	changed   = false // a dry run found changes
 	stdout    = log.New(os.Stdout, "", 0)
+	stderr    = log.New(os.Stderr, "", 0)
 	null      = log.New(ioutil.Discard, "", 0)
 	logg      = stdout
 	goSrcPath = packages.GoPathSrc("")
 )
 
+// errSkip skips a package, which is not converted.
+var errSkip = errors.New("skip")
+
+func init() {
+	flag.BoolVar(dryRun, "diff", false, "same as -n")
+}
+
 func fatal(err error) {
 	logg.Fatal(err)
 }
 
-func dir(fromDir string, cfg Config, repo Repository,
-	imports map[string]string) error {
-
-	toRepo := filepath.Join(cfg.To, repo.Name)
-	toDir := strings.Replace(fromDir, cfg.From, toRepo, 1)
-	fromRepo := strings.Replace(fromDir, goSrcPath, "", 1)[1:]
+// destination returns the destination dir of fromDir and the import
+// paths of both.
+func destination(fromDir string, cfg Config, repo Repository) (toDir,
+	fromRepo, toRepo string) {
+	toRepo = filepath.Join(cfg.To, repo.Name)
+	toDir = strings.Replace(fromDir, cfg.From, toRepo, 1)
+	fromRepo = strings.Replace(fromDir, goSrcPath, "", 1)[1:]
 	toRepo = strings.Replace(toDir, goSrcPath, "", 1)[1:]
+	return toDir, fromRepo, toRepo
+}
+
+func dir(t *task) error {
+	fromDir, cfg, repo, logg := t.fromDir, t.cfg, t.repo, t.logg
+	toDir, fromRepo, toRepo := destination(fromDir, cfg, repo)
 	logg.Printf("%s -> %s:\n", fromRepo, toRepo)
-	// phase 0: make repo empty
-	logg.Printf("- Empty %q ...\n", toRepo)
-	os.MkdirAll(toDir, 0777)
-	empty(toDir)
-	// phase 1: convert types
-	logg.Printf("- Convert type from %q to %q ...\n",
-		cfg.FromType, repo.ToType)
-	pkgs, err := packages.New(fromDir)
+	if !*noCache {
+		ok, err := cached(fromDir, toDir, cfg, repo)
+		if err != nil {
+			return err
+		}
+		if ok {
+			t.status = "OK"
+			logg.Printf("- OK (unchanged)\n\n")
+			return nil
+		}
+	}
+	// convert in a staging dir, which starts from the current files,
+	// so that the previous output is kept if anything fails
+	stageDir, err := stagingDir(toDir)
 	if err != nil {
 		return err
 	}
-	if err := pkgs.Error(); err != nil { // no type error allowed
+	defer os.RemoveAll(stageDir)
+	if err := copyDir(toDir, stageDir); err != nil {
 		return err
 	}
-	pkgs.Convert(cfg.FromType, repo.ToType, toDir, cfg.Skip, imports)
-	if err := pkgs.Save(toDir); err != nil {
+	err = convert(t, stageDir, toRepo)
+	if err == errSkip {
+		// allow these errors to be fixed
+		t.status = "SKIP"
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	// phase 2: fix type conflicts
-	logg.Printf("- Fix type conflicts ...\n")
-	prevN := maxInt
-	n := prevN - 1
-	count := 0
-	var pkgsSnippets map[string]packages.Set
-	for n != 0 && n < prevN {
-		prevN = n
-		pkgs, err = packages.New(toDir)
-		if err != nil {
+	t.status = "OK"
+	if !*dryRun {
+		// conflicts are committed, so that they can be resolved
+		if err := commit(stageDir, toDir); err != nil {
 			return err
 		}
-		pkgs.SetSnippets(pkgsSnippets)
-		n, err = pkgs.Fix(cfg.FromType, repo.ToType, cfg.LogConflicts)
+	} else {
+		diff, err := diffDir(toDir, stageDir, toRepo)
 		if err != nil {
-			logg.Printf("- Error during fixing type conflicts")
 			return err
 		}
-		pkgsSnippets = pkgs.Snippets()
-		count += n
+		if len(diff) > 0 {
+			t.changed = true
+			t.out.WriteString(diff)
+		}
 	}
-	if count == 0 {
-		logg.Printf("  ... no type conflicts found.\n")
-	} else {
-		logg.Printf("  ... fixed %d type conflicts.\n", count)
+	if t.conflicts > 0 {
+		return contextErr("%d merge conflicts in %q", t.conflicts, toRepo)
 	}
-	// phase 3: fix format verbs
-	logg.Printf("- Format %q ...\n", toRepo)
-	pkgs, err = packages.New(toDir)
+	return nil
+}
+
+// convert converts the go files of the dir of a task and saves them
+// in toDir.
+func convert(t *task, toDir, toRepo string) error {
+	fromDir, cfg, repo, logg := t.fromDir, t.cfg, t.repo, t.logg
+	// phase 0: remove the generated files (manifest.go)
+	logg.Printf("- Clean %q ...\n", toRepo)
+	generated, err := generatedNames(fromDir, repo)
 	if err != nil {
 		return err
 	}
-	if err := pkgs.Error(); err != nil { // no type error allowed
+	os.MkdirAll(toDir, 0777)
+	unknown, edits, err := clean(toDir, generated, *force)
+	if err != nil {
 		return err
 	}
-	if err := pkgs.Format(cfg.FromType, cfg.FormatVar, cfg.FormatFunc,
-		cfg.Printf); err != nil {
-		logg.Printf("  Please fix: %s\n- SKIP\n\n", err)
-		_ = os.RemoveAll(toDir) // discard error
-		// allow these errors to be fixed
-		return nil
+	for _, name := range sortedKeys(unknown) {
+		logg.Printf("\t? unknown file %q is kept\n", name)
 	}
-	if err := pkgs.Save(toDir); err != nil {
+	// phase 1: convert types of every build variant
+	types := repo.typeMap(cfg.FromType)
+	reverse := packages.Reverse{Rounding: repo.Rounding, Scale: repo.Scale}
+	var lossy []packages.Lossy
+	for _, b := range cfg.Builds {
+		logg.Printf("- Convert types (%s)%s ...\n", types, b.label(cfg.Builds))
+		pkgs, err := packages.NewContext(fromDir, b.context())
+		if err != nil {
+			return err
+		}
+		pkgs.SetOutput(&t.log)
+		if err := pkgs.Error(); err != nil { // no type error allowed
+			return err
+		}
+		pkgs.SetAlias(repo.Alias, repo.ToType)
+		pkgs.SetOperators(cfg.Operators)
+		pkgs.SetReverse(reverse)
+		pkgs.Convert(types, toDir, cfg.Skip, t.imports)
+		lossy = append(lossy, pkgs.Lossy()...)
+		if err := pkgs.Save(toDir); err != nil {
+			return err
+		}
+	}
+	unmatched, err := copyUnmatched(fromDir, toDir, cfg.Builds)
+	if err != nil {
 		return err
 	}
-	// phase 4: header, patches and footer (utils.go)
-	count, err = patch(toDir, cfg.Header, cfg.Patches, cfg.Footer)
+	for _, name := range unmatched {
+		logg.Printf("\t? %q is in no build and copied unchanged\n", name)
+	}
+	// phases 2 and 3 for every build variant (each one continues with
+	// the fixes of the previous ones)
+	reported := packages.Set{}
+	for _, b := range cfg.Builds {
+		if err := fixBuild(t, b, toDir, toRepo, reverse, lossy,
+			reported); err != nil {
+			return err
+		}
+	}
+	lost, err := packages.LostComments(fromDir, toDir)
 	if err != nil {
 		return err
 	}
-	if count > 1 {
-		logg.Printf("- Applied %d patches to %q ...\n", count, toRepo)
-	} else if count == 1 {
-		logg.Printf("- Applied one patch to %q ...\n", toRepo)
+	if len(lost) > 0 {
+		logg.Printf("  ... %d comments lost or moved:\n", len(lost))
+		for _, c := range lost {
+			logg.Printf("\t? %s\n", c)
+		}
+	}
+	// phase 4: patches, header, footer and diff files (patch.go,
+	// header.go)
+	results, err := patch(toDir, cfg.Patches, unknown, false)
+	if err != nil {
+		return err
+	}
+	_, fromRepo, _ := destination(fromDir, cfg, repo)
+	if err := decorate(toDir, newHeaderData(fromDir, fromRepo), cfg.Header,
+		cfg.Footer, unknown); err != nil {
+		return err
+	}
+	if updateDiffs {
+		// the patches command writes the diff files instead
+		logPatches(t, toRepo, results)
+		return writeDiffs(t, toDir, cfg.Patches, unknown)
+	}
+	diffResults, err := patch(toDir, cfg.Patches, unknown, true)
+	if err != nil {
+		return err
+	}
+	err = logPatches(t, toRepo, append(results, diffResults...))
+	if patchesOnly {
+		// the patches command stops here
+		if checkStale {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 	// phase 5: copy non-go files (utils.go)
 	logg.Printf("- Copy non-go files of %q ...\n", toRepo)
 	if err := copyFiles(fromDir, toDir, cfg.ReadMe); err != nil {
 		return err
 	}
-	logg.Printf("- OK\n\n")
+	if *harness {
+		logg.Printf("- Generate differential test of %q ...\n", toRepo)
+		_, fromRepo, _ := destination(fromDir, cfg, repo)
+		src, n, err := packages.DiffHarness(fromDir, toDir, fromRepo,
+			types)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			if err := ioutil.WriteFile(filepath.Join(toDir, harnessName),
+				src, 0666); err != nil {
+				return context(err)
+			}
+		}
+		logg.Printf("  ... %d functions compared.\n", n)
+	}
+	if *examples {
+		logg.Printf("- Rewrite example output of %q ...\n", toRepo)
+		outputs, err := exampleOutputs(toDir, toRepo)
+		if err != nil {
+			return err
+		}
+		n, err := packages.RewriteExamples(toDir, outputs)
+		if err != nil {
+			return err
+		}
+		logg.Printf("  ... %d examples rewritten.\n", n)
+	}
+	if *goldenRun {
+		if err := compareOutput(t, toDir, toRepo); err != nil {
+			return err
+		}
+	}
+	// merge manual edits (manifest.go)
+	files, err := readFiles(toDir)
+	if err != nil {
+		return err
+	}
+	t.conflicts = 0
+	if len(edits) > 0 {
+		logg.Printf("- Merge manual edits of %q ...\n", toRepo)
+		conflicts, err := mergeEdits(toDir, edits)
+		if err != nil {
+			return err
+		}
+		for _, name := range conflictNames(conflicts) {
+			logg.Printf("\t! %d merge conflicts in %q\n", conflicts[name],
+				name)
+			t.conflicts += conflicts[name]
+		}
+	}
+	if err := saveManifest(toDir, fromDir, configHash(cfg, repo), files,
+		unknown, t.conflicts); err != nil {
+		return err
+	}
+	// phase 6: build, vet and test (verify.go)
+	if *verifyPkg {
+		logg.Printf("- Verify %q ...\n", toRepo)
+		failures, err := verify(toDir, toRepo)
+		if err != nil {
+			return err
+		}
+		if len(failures) > 0 {
+			for _, line := range failures {
+				logg.Printf("\t! %s\n", line)
+			}
+			return contextErr("verification of %q failed", toRepo)
+		}
+	}
+	if t.conflicts == 0 {
+		// otherwise dir fails after committing the conflicts
+		logg.Printf("- OK\n\n")
+	}
 	return nil
 }
 
-// recurse converts all files in a dir and all subdirs
-func recurse(fromDir string, config Config, repo Repository,
-	imports map[string]string) error {
+// fixBuild fixes the type conflicts (phase 2) and the format verbs
+// (phase 3) of a build variant of the converted packages in toDir.
+// The lossy sites of the conversion and of the fixes are reported,
+// unless they were reported already.
+func fixBuild(t *task, b Build, toDir, toRepo string,
+	reverse packages.Reverse, lossy []packages.Lossy,
+	reported packages.Set) error {
+	cfg, repo, logg := t.cfg, t.repo, t.logg
+	types := repo.typeMap(cfg.FromType)
+	label := b.label(cfg.Builds)
+	// continue in memory with the converted packages
+	pkgs, err := packages.NewContext(toDir, b.context())
+	if err != nil {
+		return err
+	}
+	pkgs.SetOutput(&t.log)
+	pkgs.SetImportPath(toRepo) // for the external test package
+	pkgs.SetAlias(repo.Alias, repo.ToType)
+	pkgs.SetOperators(cfg.Operators)
+	pkgs.SetReverse(reverse)
+	rewritten := packages.Set{}
+	for _, from := range types.From() {
+		to := types[from]
+		ops, ok := cfg.Operators[to]
+		if _, done := rewritten[to]; !ok || done {
+			continue
+		}
+		rewritten[to] = struct{}{}
+		logg.Printf("- Rewrite operators on %q%s ...\n", to, label)
+		if _, err := pkgs.RewriteOperators(to, ops); err != nil {
+			return err
+		}
+		if err := pkgs.Recheck(); err != nil {
+			return err
+		}
+	}
+	// phase 2: fix type conflicts
+	logg.Printf("- Fix type conflicts%s ...\n", label)
+	count, remaining, err := pkgs.FixAll(types, cfg.LogConflicts)
+	if err != nil {
+		logg.Printf("- Error during fixing type conflicts")
+		return err
+	}
+	switch {
+	case count > 0:
+		logg.Printf("  ... fixed %d type conflicts.\n", count)
+	case len(remaining) == 0:
+		logg.Printf("  ... no type conflicts found.\n")
+	}
+	var sites []string
+	for _, l := range append(lossy, pkgs.Lossy()...) {
+		site := fmt.Sprint(l)
+		if _, ok := reported[site]; !ok {
+			reported[site] = struct{}{}
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) > 0 {
+		logg.Printf("  ... lost precision at %d sites:\n", len(sites))
+		for _, site := range sites {
+			logg.Printf("\t! %s\n", site)
+		}
+	}
+	if len(remaining) > 0 {
+		logg.Printf("  ... %d type conflicts could not be fixed:\n",
+			len(remaining))
+		for _, err := range remaining {
+			logg.Printf("\t- %s\n", err)
+		}
+		return contextErr("unfixed type conflicts in %q%s", toRepo, label)
+	}
+	if n := pkgs.Cleanup(); n > 0 {
+		logg.Printf("  ... removed %d redundant conversions.\n", n)
+		if err := pkgs.Recheck(); err != nil {
+			return err
+		}
+	}
+	// patch operations on the type checked package (before the
+	// format verbs, which may call the methods they add)
+	if len(cfg.Ops) > 0 {
+		logg.Printf("- Apply patch operations to %q%s ...\n", toRepo,
+			label)
+		count := 0
+		for _, base := range sortedOps(cfg.Ops) {
+			n, err := pkgs.ApplyOps(base, cfg.Ops[base])
+			if err != nil {
+				return context(err)
+			}
+			count += n
+		}
+		logg.Printf("  ... applied %d operations.\n", count)
+	}
+	// phase 3: fix format verbs
+	logg.Printf("- Format %q%s ...\n", toRepo, label)
+	if err := pkgs.Format(types, cfg.FormatVar, cfg.FormatFunc,
+		cfg.Printf); err != nil {
+		logg.Printf("  Please fix: %s\n- SKIP\n\n", err)
+		return errSkip
+	}
+	return pkgs.Save(toDir)
+}
 
+// compareOutput compares the output of a converted main package with
+// the original one (golden.go).
+func compareOutput(t *task, toDir, toRepo string) error {
+	ok, err := isMain(t.fromDir)
+	if err != nil || !ok {
+		return err
+	}
+	t.logg.Printf("- Compare output of %q ...\n", toRepo)
+	_, fromRepo, _ := destination(t.fromDir, t.cfg, t.repo)
+	diff, err := golden(t.fromDir, fromRepo, toDir, toRepo,
+		t.cfg.Args[filepath.Base(t.fromDir)])
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		t.logg.Printf("  ... same output.\n")
+		return nil
+	}
+	t.logg.Printf("  ... output differs:\n")
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(diff, "\n"),
+		"\n") {
+		t.logg.Printf("\t%s", line)
+	}
+	t.logg.Printf("\n")
+	return nil
+}
+
+// recurse returns a dir and all its subdirs
+func recurse(fromDir string) ([]string, error) {
+	var dirs []string
 	walk := func(sub string, info os.FileInfo, err error) error {
 		if !info.IsDir() {
 			return nil
@@ -243,23 +854,36 @@ func recurse(fromDir string, config Config, repo Repository,
 		if info.Name()[0] == '.' {
 			return filepath.SkipDir
 		}
-		return dir(sub, config, repo, imports)
+		dirs = append(dirs, sub)
+		return nil
 	}
-	return filepath.Walk(fromDir, walk)
+	return dirs, filepath.Walk(fromDir, walk)
 }
 
 func run() error {
 	var err error
+	args := flag.Args()
+	handle := dirFunc(dir)
+	if len(args) > 0 && args[0] == "status" {
+		handle = statusDir
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "patches" {
+		handle = patchesDir
+		if args, err = patchesCommand(args[1:]); err != nil {
+			return err
+		}
+	}
 	cfgJson := "svgo.json"
-	n := len(os.Args)
-	if n > 1 && os.Args[n-1][0] != '-' {
-		cfgJson = os.Args[n-1]
+	if len(args) > 0 {
+		cfgJson = args[len(args)-1]
 	}
 	logg.Printf("Open %q ...", cfgJson)
 	repos, cfg, err := Open(cfgJson)
 	if err != nil {
 		return context(err)
 	}
+	var tasks []*task
 	for _, repo := range repos {
 		if repo.Disabled {
 			break
@@ -267,17 +891,17 @@ func run() error {
 		fromDir := packages.GoPathSrc(cfg.From)
 		toRepo := fmt.Sprintf("%s/%s", cfg.To, repo.Name)
 		imports := map[string]string{cfg.From: toRepo}
+		dirs := []string{fromDir}
 		if repo.Recurse {
-			if err := recurse(fromDir, cfg, repo, imports); err != nil {
-				return err
-			}
-		} else {
-			if err := dir(fromDir, cfg, repo, imports); err != nil {
-				return err
+			if dirs, err = recurse(fromDir); err != nil {
+				return context(err)
 			}
 		}
+		for _, dirname := range dirs {
+			tasks = append(tasks, newTask(dirname, cfg, repo, imports))
+		}
 	}
-	return nil
+	return runTasks(tasks, handle, *jobs)
 }
 
 func main() {
@@ -286,8 +910,20 @@ func main() {
 	if *verbose {
 		logg = stdout
 	}
+	if *dryRun {
+		// keep stdout for the diff
+		logg = stderr
+		packages.Output = os.Stderr
+	}
 	if err := run(); err != nil {
+		if *dryRun {
+			logg.Printf("Error: %s\n", err)
+			os.Exit(2)
+		}
 		stdout.Fatalf("Error: %s\n", err)
 	}
 	logg.Println("Done without errors.")
+	if changed {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stanim/typewriter/packages"
+)
+
+// manifestName is the name of the manifest in a destination folder.
+// (It is hidden, so it is not copied or converted.)
+const manifestName = ".gofloat.json"
+
+// pristineName is the name of the hidden folder with the generated
+// text files as they were generated (before manual edits).
+const pristineName = ".gofloat"
+
+// harnessName is the name of the generated differential test (see
+// the -harness flag).
+const harnessName = "gofloat_diff_test.go"
+
+type (
+	// manifest lists the files which are generated in a folder with
+	// the hash of their content.
+	manifest struct {
+		Files  map[string]string // sha256 by file name
+		Config string            // hash of the configuration
+		Source source            // at the time of generation
+
+		// number of merge conflicts, which are marked in the files
+		Conflicts int `json:",omitempty"`
+	}
+	// edit is a generated file, which was edited manually.
+	edit struct {
+		base   []byte // as generated
+		manual []byte // as edited
+	}
+)
+
+// hash returns the sha256 hash of a file content.
+func hash(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// readManifest reads the manifest of a folder. A folder without
+// manifest has no generated files.
+func readManifest(dirname string) (manifest, error) {
+	m := manifest{Files: map[string]string{}}
+	buf, err := ioutil.ReadFile(filepath.Join(dirname, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, context(err)
+	}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return m, context(err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]string{}
+	}
+	return m, nil
+}
+
+// save writes the manifest to a folder.
+func (m manifest) save(dirname string) error {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return context(err)
+	}
+	return context(ioutil.WriteFile(filepath.Join(dirname, manifestName),
+		append(buf, '\n'), 0666))
+}
+
+// generatedNames returns the names of the files, which gofloat will
+// generate in the destination of fromDir.
+func generatedNames(fromDir string, repo Repository) (packages.Set,
+	error) {
+	infos, err := ioutil.ReadDir(fromDir)
+	if err != nil {
+		return nil, context(err)
+	}
+	names := packages.Set{"snippets.go": {}}
+	if repo.Alias != "" {
+		names[strings.ToLower(repo.Alias)+".go"] = struct{}{}
+	}
+	if *harness {
+		names[harnessName] = struct{}{}
+	}
+	if *goldenRun {
+		names[goldenName] = struct{}{}
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || name[0] == '.' || strings.HasSuffix(name, "~") {
+			continue
+		}
+		names[name] = struct{}{}
+	}
+	return names, nil
+}
+
+// clean removes the files of a previous run, which are listed in the
+// manifest. Generated files which were edited manually are returned
+// as edits, so that they can be merged after the conversion (unless
+// force is set). Edited files without pristine copy and unknown
+// files, which would be overwritten, are an error unless force is
+// set. It also returns the hashes of the unknown files, which are
+// kept.
+func clean(dirname string, generated packages.Set, force bool) (
+	map[string]string, map[string]edit, error) {
+	m, err := readManifest(dirname)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := readFiles(dirname)
+	if err != nil {
+		return nil, nil, err
+	}
+	hs := hashes(files)
+	unknown := map[string]string{}
+	edits := map[string]edit{}
+	var remove []string
+	for _, name := range sortedKeys(hs) {
+		h, ok := m.Files[name]
+		_, overwrite := generated[name]
+		switch {
+		case ok && h != hs[name] && !force:
+			base, err := ioutil.ReadFile(filepath.Join(dirname,
+				pristineName, name))
+			if err != nil || !overwrite {
+				return nil, nil, contextErr("%q was modified since it "+
+					"was generated (use -f to overwrite it)",
+					filepath.Join(dirname, name))
+			}
+			edits[name] = edit{base: base, manual: files[name]}
+		case !ok && overwrite && !force:
+			return nil, nil, contextErr("%q is not generated by "+
+				"gofloat, but would be overwritten (use -f to "+
+				"overwrite it)", filepath.Join(dirname, name))
+		case !ok && !overwrite:
+			unknown[name] = hs[name]
+			continue
+		}
+		remove = append(remove, name)
+	}
+	// only remove files if all files are checked
+	for _, name := range remove {
+		if err := os.Remove(filepath.Join(dirname, name)); err != nil {
+			return nil, nil, context(err)
+		}
+	}
+	return unknown, edits, nil
+}
+
+// saveManifest saves the manifest of the generated files in a folder
+// except the unknown files, which were not changed, the hash of the
+// configuration, the state of the source in fromDir and the number of
+// merge conflicts. Pristine copies of the generated text files are
+// saved as well. The files are read before manual edits are merged,
+// so that the edits are found again by the next clean.
+func saveManifest(dirname, fromDir, config string,
+	files map[string][]byte, unknown map[string]string,
+	conflicts int) error {
+	src, err := readSource(fromDir)
+	if err != nil {
+		return err
+	}
+	pristine := filepath.Join(dirname, pristineName)
+	if err := os.RemoveAll(pristine); err != nil {
+		return context(err)
+	}
+	if err := os.Mkdir(pristine, 0777); err != nil {
+		return context(err)
+	}
+	m := manifest{Files: map[string]string{}, Config: config,
+		Source: src, Conflicts: conflicts}
+	for name, buf := range files {
+		h := hash(buf)
+		if unknown[name] == h {
+			continue
+		}
+		m.Files[name] = h
+		if bytes.IndexByte(buf, 0) >= 0 {
+			continue // binary
+		}
+		if err := ioutil.WriteFile(filepath.Join(pristine, name), buf,
+			0666); err != nil {
+			return context(err)
+		}
+	}
+	return m.save(dirname)
+}
+
+// mergeEdits merges the manual edits of files with their new
+// generated content. Conflicts are marked with merge markers. It
+// returns the number of conflicts by file name, which includes
+// markers of previous conflicts that are not resolved yet.
+func mergeEdits(dirname string, edits map[string]edit) (map[string]int,
+	error) {
+	conflicts := map[string]int{}
+	for name, e := range edits {
+		filename := filepath.Join(dirname, name)
+		buf, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			// the file is not generated anymore: keep the edits
+			if err := ioutil.WriteFile(filename, e.manual,
+				0666); err != nil {
+				return nil, context(err)
+			}
+			conflicts[name] = conflictMarkers(e.manual)
+			continue
+		}
+		if err != nil {
+			return nil, context(err)
+		}
+		merged, _ := packages.Merge(e.base, e.manual, buf, "manual",
+			"generated")
+		if err := ioutil.WriteFile(filename, merged, 0666); err != nil {
+			return nil, context(err)
+		}
+		conflicts[name] = conflictMarkers(merged)
+	}
+	return conflicts, nil
+}
+
+// conflictMarkers counts the merge conflicts, which are marked in a
+// file.
+func conflictMarkers(buf []byte) int {
+	n := 0
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("<<<<<<< ")) {
+			n++
+		}
+	}
+	return n
+}
+
+// conflictNames returns the sorted names of the files with merge
+// conflicts.
+func conflictNames(conflicts map[string]int) []string {
+	names := []string{}
+	for name, n := range conflicts {
+		if n > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashes returns the hashes of file contents by file name.
+func hashes(files map[string][]byte) map[string]string {
+	m := map[string]string{}
+	for name, buf := range files {
+		m[name] = hash(buf)
+	}
+	return m
+}
+
+// sortedKeys returns the sorted keys of a map.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/stanim/typewriter/packages"
+)
+
+func TestClean(t *testing.T) {
+	const gen, edited = "// generated\n", "// edited\n"
+	tests := []struct {
+		name     string
+		files    map[string]string // in the folder
+		manifest map[string]string // content at the generation
+		pristine []string          // files with a pristine copy
+		force    bool
+		err      bool
+		kept     []string // files which are not removed
+		unknown  []string
+		edits    []string
+	}{
+		{name: "generated",
+			files:    map[string]string{"a.go": gen, "b.go": gen},
+			manifest: map[string]string{"a.go": gen, "b.go": gen}},
+		{name: "edited",
+			files:    map[string]string{"a.go": edited, "b.go": gen},
+			manifest: map[string]string{"a.go": gen, "b.go": gen},
+			pristine: []string{"a.go"}, edits: []string{"a.go"}},
+		{name: "edited without pristine copy",
+			files:    map[string]string{"a.go": edited},
+			manifest: map[string]string{"a.go": gen}, err: true},
+		{name: "edited without pristine copy and force",
+			files:    map[string]string{"a.go": edited},
+			manifest: map[string]string{"a.go": gen}, force: true},
+		{name: "edited and not generated anymore",
+			files:    map[string]string{"c.go": edited},
+			manifest: map[string]string{"c.go": gen},
+			pristine: []string{"c.go"}, err: true},
+		{name: "unknown",
+			files: map[string]string{"a.go": gen, "own.go": edited,
+				"README": edited},
+			manifest: map[string]string{"a.go": gen},
+			kept:     []string{"README", "own.go"},
+			unknown:  []string{"README", "own.go"}},
+		{name: "unknown would be overwritten",
+			files:    map[string]string{"a.go": gen, "b.go": edited},
+			manifest: map[string]string{"a.go": gen}, err: true},
+		{name: "unknown would be overwritten and force",
+			files:    map[string]string{"a.go": gen, "b.go": edited},
+			manifest: map[string]string{"a.go": gen}, force: true},
+		{name: "no manifest",
+			files: map[string]string{"own.go": edited},
+			kept:  []string{"own.go"}, unknown: []string{"own.go"}},
+	}
+	for _, test := range tests {
+		dirname, err := ioutil.TempDir("", "gofloat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dirname)
+		for name, content := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dirname, name),
+				[]byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.manifest != nil {
+			m := manifest{Files: map[string]string{}}
+			for name, content := range test.manifest {
+				m.Files[name] = hash([]byte(content))
+			}
+			if err := m.save(dirname); err != nil {
+				t.Fatal(err)
+			}
+		}
+		os.Mkdir(filepath.Join(dirname, pristineName), 0777)
+		for _, name := range test.pristine {
+			if err := ioutil.WriteFile(filepath.Join(dirname, pristineName,
+				name), []byte(test.manifest[name]), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// a.go and b.go are generated again
+		generated := packages.Set{"a.go": {}, "b.go": {}}
+		unknown, edits, err := clean(dirname, generated, test.force)
+		if test.err != (err != nil) {
+			t.Errorf("%s: got error %v, want error %t", test.name, err,
+				test.err)
+			continue
+		}
+		files, err := readFiles(dirname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.err {
+			// nothing is removed
+			if len(files) != len(test.files) {
+				t.Errorf("%s: got %d files after an error, want %d",
+					test.name, len(files), len(test.files))
+			}
+			continue
+		}
+		if got := sortedNames(files); !equalNames(got, test.kept) {
+			t.Errorf("%s: got kept files %v, want %v", test.name, got,
+				test.kept)
+		}
+		if got := sortedKeys(unknown); !equalNames(got, test.unknown) {
+			t.Errorf("%s: got unknown files %v, want %v", test.name, got,
+				test.unknown)
+		}
+		for _, name := range test.unknown {
+			if unknown[name] != hash([]byte(test.files[name])) {
+				t.Errorf("%s: wrong hash of unknown file %q", test.name,
+					name)
+			}
+		}
+		var editNames []string
+		for name, e := range edits {
+			editNames = append(editNames, name)
+			if string(e.base) != test.manifest[name] ||
+				string(e.manual) != test.files[name] {
+				t.Errorf("%s: got edit %q of %q, want %q of %q", test.name,
+					e.manual, e.base, test.files[name], test.manifest[name])
+			}
+		}
+		sort.Strings(editNames)
+		if !equalNames(editNames, test.edits) {
+			t.Errorf("%s: got edits %v, want %v", test.name, editNames,
+				test.edits)
+		}
+	}
+}
+
+// sortedNames returns the sorted file names of files.
+func sortedNames(files map[string][]byte) []string {
+	return sortedKeys(hashes(files))
+}
+
+// equalNames compares two lists of names, where nil and empty are
+// equal.
+func equalNames(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stanim/typewriter/packages"
+)
+
+// patchResult is the result of a patch on a file.
+type patchResult struct {
+	file     string // base name
+	index    int    // of the patch in the patches of the file
+	patch    Patch
+	count    int      // replacements or applied hunks
+	near     string   // nearest match if none: "svg.go:12: ..."
+	rejected int      // hunks of a diff
+	rejFile  string   // with the rejected hunks
+	moved    []string // hunks of a diff applied with offset or fuzz
+}
+
+// stale checks if a patch did not apply as expected.
+func (r patchResult) stale() bool {
+	if r.patch.Diff != "" {
+		return r.patch.missing || r.count == 0 || r.rejected > 0
+	}
+	if r.patch.Count > 0 {
+		return r.count != r.patch.Count
+	}
+	return r.count == 0
+}
+
+// String implements the fmt.Stringer interface.
+// (For example `svg.go #2: 1 replacement of "type SVG struct {"`.)
+func (r patchResult) String() string {
+	if r.patch.Diff != "" {
+		if r.patch.missing {
+			return fmt.Sprintf("%s #%d: diff file %q not found", r.file,
+				r.index+1, r.patch.Diff)
+		}
+		s := fmt.Sprintf("%s #%d: %d hunks of %q applied", r.file,
+			r.index+1, r.count, filepath.Base(r.patch.Diff))
+		if r.rejected > 0 {
+			s += fmt.Sprintf(", %d rejected", r.rejected)
+			if r.rejFile != "" {
+				s += fmt.Sprintf(" (see %q)", r.rejFile)
+			}
+		} else if r.count == 0 {
+			s += ", expected at least 1"
+		}
+		return s
+	}
+	s := fmt.Sprintf("%s #%d: %d replacement", r.file, r.index+1, r.count)
+	if r.count != 1 {
+		s += "s"
+	}
+	s += fmt.Sprintf(" of %q", abbreviate(firstLine(r.patch.Old)))
+	if !r.stale() {
+		return s
+	}
+	if r.patch.Count > 0 {
+		s += fmt.Sprintf(", expected %d", r.patch.Count)
+	} else {
+		s += ", expected at least 1"
+	}
+	if r.near != "" {
+		s += "\n\t  nearest match " + r.near
+	}
+	return s
+}
+
+// patch applies the text patches (or the diff files) to the go files
+// in dirname and returns their results. Unknown and missing files are
+// skipped.
+func patch(dirname string, patches map[string][]Patch,
+	unknown map[string]string, diffs bool) ([]patchResult, error) {
+	var results []patchResult
+	bases := make([]string, 0, len(patches))
+	for base := range patches {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		if strings.ToLower(filepath.Ext(base)) != ".go" {
+			continue
+		}
+		if _, ok := unknown[base]; ok {
+			continue
+		}
+		filename := filepath.Join(dirname, base)
+		buf, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, context(err)
+		}
+		source := string(buf)
+		for i, p := range patches[base] {
+			if (p.Diff != "") != diffs {
+				continue
+			}
+			if diffs {
+				r, err := applyDiff(&source, base, i, p)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, r)
+				continue
+			}
+			if p.Old == "" {
+				return nil, contextErr("%s #%d: empty 'Old'", base, i+1)
+			}
+			r := patchResult{file: base, index: i, patch: p}
+			source, r.count = applyPatch(source, p)
+			if r.count == 0 {
+				line, text := nearest(source, p.Old)
+				r.near = fmt.Sprintf("%s:%d: %s", base, line,
+					strings.TrimSpace(text))
+			}
+			results = append(results, r)
+		}
+		if err := ioutil.WriteFile(filename, []byte(source),
+			0666); err != nil {
+			return nil, context(err)
+		}
+	}
+	return results, nil
+}
+
+// applyPatch replaces patch.Old by patch.New in source from the byte
+// offset patch.Start on (at most patch.N times if it is positive). It
+// returns the new source and the number of replacements.
+func applyPatch(source string, p Patch) (string, int) {
+	if p.Start < 0 || p.Start > len(source) {
+		return source, 0
+	}
+	n := strings.Count(source[p.Start:], p.Old)
+	if p.N > 0 && n > p.N {
+		n = p.N
+	}
+	return source[:p.Start] + strings.Replace(source[p.Start:], p.Old,
+		p.New, n), n
+}
+
+// applyDiff applies the hunks of a diff file, which change base, to
+// the source. (A diff of a single file is applied anyway.) In a real
+// run (not -n or the patches command) the rejected hunks are written
+// next to the diff file and an old reject file is removed.
+func applyDiff(source *string, base string, index int, p Patch) (
+	patchResult, error) {
+	r := patchResult{file: base, index: index, patch: p}
+	rejects := ""
+	for _, fd := range p.diffs {
+		if len(p.diffs) > 1 && filepath.Base(fd.New) != base {
+			continue
+		}
+		src, result := fd.Apply([]byte(*source))
+		*source = string(src)
+		r.count += result.Applied
+		r.rejected += result.Rejected
+		r.moved = append(r.moved, result.Moved...)
+		rejects += result.Rejects
+	}
+	if *dryRun || patchesOnly {
+		return r, nil
+	}
+	rejFile := p.Diff + ".rej"
+	if rejects == "" {
+		if err := os.Remove(rejFile); err != nil && !os.IsNotExist(err) {
+			return r, context(err)
+		}
+		return r, nil
+	}
+	if err := ioutil.WriteFile(rejFile, []byte(rejects), 0666); err != nil {
+		return r, context(err)
+	}
+	r.rejFile = rejFile
+	return r, nil
+}
+
+// readDiffs resolves the paths of the diff files relative to the
+// config file and parses them. A missing diff file is marked, so that
+// the patch fails, unless it is written by "patches -update". It
+// returns their contents for the hash of the configuration.
+func readDiffs(cfgPath string, patches map[string][]Patch) ([]byte,
+	error) {
+	var contents []byte
+	for _, base := range sortedPatches(patches) {
+		ps := patches[base]
+		for i := range ps {
+			if ps[i].Diff == "" {
+				continue
+			}
+			if !filepath.IsAbs(ps[i].Diff) {
+				ps[i].Diff = filepath.Join(filepath.Dir(cfgPath),
+					ps[i].Diff)
+			}
+			buf, err := ioutil.ReadFile(ps[i].Diff)
+			if os.IsNotExist(err) {
+				ps[i].missing = true
+				continue
+			}
+			if err != nil {
+				return nil, context(err)
+			}
+			if ps[i].diffs, err = packages.ParseDiff(buf); err != nil {
+				return nil, contextErr("diff %q: %s", ps[i].Diff, err)
+			}
+			contents = append(contents, buf...)
+		}
+	}
+	return contents, nil
+}
+
+// sortedPatches returns the sorted filenames of patches.
+func sortedPatches(patches map[string][]Patch) []string {
+	names := make([]string, 0, len(patches))
+	for name := range patches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeDiffs regenerates the diff files from the manual edits of the
+// destination. The diff of a file is the difference between its
+// generated source in stageDir (without diff files) and the file in
+// the destination. Only the first diff file of a file is written.
+func writeDiffs(t *task, stageDir string, patches map[string][]Patch,
+	unknown map[string]string) error {
+	toDir, _, _ := destination(t.fromDir, t.cfg, t.repo)
+	for _, base := range sortedPatches(patches) {
+		if _, ok := unknown[base]; ok {
+			continue
+		}
+		for _, p := range patches[base] {
+			if p.Diff == "" {
+				continue
+			}
+			generated, err := ioutil.ReadFile(filepath.Join(stageDir, base))
+			if os.IsNotExist(err) {
+				break
+			}
+			if err != nil {
+				return context(err)
+			}
+			edited, err := ioutil.ReadFile(filepath.Join(toDir, base))
+			if os.IsNotExist(err) {
+				break
+			}
+			if err != nil {
+				return context(err)
+			}
+			t.logg.Printf("- Update %q ...\n", p.Diff)
+			diff := packages.UnifiedDiff("a/"+base, "b/"+base, generated,
+				edited)
+			if diff == "" {
+				// an empty diff file would fail
+				t.logg.Printf("\t! no manual edits of %q: remove the diff "+
+					"file from the patches\n", base)
+				break
+			}
+			t.logg.Printf("  ... %d lines of %q changed.\n",
+				changedLines(diff), base)
+			if err := ioutil.WriteFile(p.Diff, []byte(diff),
+				0666); err != nil {
+				return context(err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// changedLines counts the removed and added lines of a unified diff.
+func changedLines(diff string) int {
+	n := 0
+	for _, line := range strings.Split(diff, "\n") {
+		if (strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")) &&
+			!strings.HasPrefix(line, "---") &&
+			!strings.HasPrefix(line, "+++") {
+			n++
+		}
+	}
+	return n
+}
+
+// logPatches logs the results of the patches and returns an error if
+// any patch is stale.
+func logPatches(t *task, toRepo string, results []patchResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	if len(results) == 1 {
+		t.logg.Printf("- Apply one patch to %q ...\n", toRepo)
+	} else {
+		t.logg.Printf("- Apply %d patches to %q ...\n", len(results),
+			toRepo)
+	}
+	stale := 0
+	for _, r := range results {
+		if r.stale() {
+			stale++
+			t.logg.Printf("\t! %s\n", r)
+		} else if *verbose || patchesOnly {
+			t.logg.Printf("\t+ %s\n", r)
+		}
+		for _, moved := range r.moved {
+			t.logg.Printf("\t? %s #%d: %s\n", r.file, r.index+1, moved)
+		}
+	}
+	if stale > 0 {
+		return contextErr("%d of %d patches of %q did not apply", stale,
+			len(results), toRepo)
+	}
+	return nil
+}
+
+// firstLine returns the first non-blank line of s without
+// indentation.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// abbreviate shortens a text for the log.
+func abbreviate(s string) string {
+	if len(s) > 40 {
+		return s[:37] + "..."
+	}
+	return s
+}
+
+// nearest returns the number and the text of the line of source which
+// is nearest (by edit distance) to the first line of old.
+func nearest(source, old string) (int, string) {
+	want := firstLine(old)
+	best, bestLine, bestText := -1, 0, ""
+	for i, line := range strings.Split(source, "\n") {
+		text := strings.TrimSpace(line)
+		if text == "" {
+			continue
+		}
+		if d := distance(text, want); best < 0 || d < best {
+			best, bestLine, bestText = d, i+1, line
+		}
+	}
+	return bestLine, bestText
+}
+
+// distance returns the edit (Levenshtein) distance of two strings.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// min3 returns the minimum of three ints.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+var (
+	patchesOnly = false // the patches command stops after the patches
+	checkStale  = false // and fails on stale patches (-check)
+	updateDiffs = false // or writes the diff files (-update)
+)
+
+// patchesCommand parses the flags of the patches command and returns
+// its remaining arguments.
+func patchesCommand(args []string) ([]string, error) {
+	patchesOnly = true
+	fs := flag.NewFlagSet("patches", flag.ContinueOnError)
+	fs.BoolVar(&checkStale, "check", false, "fail on stale patches")
+	fs.BoolVar(&updateDiffs, "update", false,
+		"write the manual edits of the destination to the diff files")
+	if err := fs.Parse(args); err != nil {
+		return nil, context(err)
+	}
+	return fs.Args(), nil
+}
+
+// patchesDir converts the dir of a task in a temporary folder and
+// reports the results of its patches. Nothing is written to the
+// destination.
+func patchesDir(t *task) error {
+	fromDir, cfg, repo, logg := t.fromDir, t.cfg, t.repo, t.logg
+	toDir, fromRepo, toRepo := destination(fromDir, cfg, repo)
+	logg.Printf("%s -> %s:\n", fromRepo, toRepo)
+	tmp, err := ioutil.TempDir("", "gofloat-patches-")
+	if err != nil {
+		return context(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyDir(toDir, tmp); err != nil {
+		return err
+	}
+	err = convert(t, tmp, toRepo)
+	if err == errSkip {
+		t.status = "SKIP"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.status = "OK"
+	logg.Printf("- OK\n\n")
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/stanim/typewriter/packages"
+)
+
+// source describes the state of a source package at the time of
+// generation.
+type source struct {
+	Hash   string   // sha256 of all files
+	Commit string   `json:",omitempty"` // git commit of a checkout
+	API    []string // exported declarations
+}
+
+// readSource returns the current state of a source package.
+func readSource(fromDir string) (source, error) {
+	var src source
+	files, err := readFiles(fromDir)
+	if err != nil {
+		return src, err
+	}
+	h := sha256.New()
+	for _, name := range sortedKeys(hashes(files)) {
+		h.Write([]byte(name + "\x00"))
+		h.Write(files[name])
+		h.Write([]byte{0})
+	}
+	src.Hash = hex.EncodeToString(h.Sum(nil))
+	src.Commit = gitCommit(fromDir)
+	src.API, err = exportedAPI(fromDir)
+	return src, err
+}
+
+// gitCommit returns the git commit of the checkout, which contains
+// dirname or an empty string.
+func gitCommit(dirname string) string {
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dirname
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// exportedAPI returns the sorted exported declarations of the go
+// packages (without tests) in dirname, eg "func New",
+// "func (*SVG) Circle", "type SVG" or "const Version".
+func exportedAPI(dirname string) ([]string, error) {
+	fset := token.NewFileSet()
+	notTest := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}
+	pkgMap, err := parser.ParseDir(fset, dirname, notTest, 0)
+	if err != nil {
+		return nil, context(err)
+	}
+	api := []string{}
+	for _, pkg := range pkgMap {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				api = append(api, declAPI(decl)...)
+			}
+		}
+	}
+	sort.Strings(api)
+	return api, nil
+}
+
+// declAPI returns the exported names of a declaration.
+func declAPI(decl ast.Decl) []string {
+	var api []string
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if !d.Name.IsExported() {
+			break
+		}
+		if d.Recv == nil || len(d.Recv.List) == 0 {
+			api = append(api, "func "+d.Name.Name)
+			break
+		}
+		recv := d.Recv.List[0].Type
+		star := ""
+		if s, ok := recv.(*ast.StarExpr); ok {
+			recv, star = s.X, "*"
+		}
+		if ident, ok := recv.(*ast.Ident); ok && ident.IsExported() {
+			api = append(api, "func ("+star+ident.Name+") "+d.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if s.Name.IsExported() {
+					api = append(api, "type "+s.Name.Name)
+				}
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					if name.IsExported() {
+						api = append(api, d.Tok.String()+" "+name.Name)
+					}
+				}
+			}
+		}
+	}
+	return api
+}
+
+// apiDiff returns the declarations which are added to and removed
+// from an API.
+func apiDiff(old, new []string) (added, removed []string) {
+	oldSet, newSet := packages.Set{}, packages.Set{}
+	for _, name := range old {
+		oldSet[name] = struct{}{}
+	}
+	for _, name := range new {
+		newSet[name] = struct{}{}
+		if _, ok := oldSet[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for _, name := range old {
+		if _, ok := newSet[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// statusDir reports if the source package of fromDir changed since
+// its last generation and which exported declarations were added or
+// removed.
+func statusDir(t *task) error {
+	fromDir, cfg, repo, logg := t.fromDir, t.cfg, t.repo, t.logg
+	toDir, fromRepo, toRepo := destination(fromDir, cfg, repo)
+	m, err := readManifest(toDir)
+	if err != nil {
+		return err
+	}
+	if m.Source.Hash == "" {
+		logg.Printf("%s -> %s: not generated\n", fromRepo, toRepo)
+		return nil
+	}
+	src, err := readSource(fromDir)
+	if err != nil {
+		return err
+	}
+	if src.Hash == m.Source.Hash {
+		logg.Printf("%s -> %s: up to date\n", fromRepo, toRepo)
+		return nil
+	}
+	t.changed = true
+	commits := ""
+	if m.Source.Commit != "" && src.Commit != m.Source.Commit {
+		commits = " (" + short(m.Source.Commit) + " -> " +
+			short(src.Commit) + ")"
+	}
+	logg.Printf("%s -> %s: changed%s\n", fromRepo, toRepo, commits)
+	added, removed := apiDiff(m.Source.API, src.API)
+	for _, name := range added {
+		logg.Printf("\t+ %s\n", name)
+	}
+	for _, name := range removed {
+		logg.Printf("\t- %s\n", name)
+	}
+	return nil
+}
+
+// short abbreviates a git commit.
+func short(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+type (
+	// task handles a single dir. Its log and output are buffered, so
+	// that tasks can run in parallel and still print their output
+	// grouped and in order.
+	task struct {
+		fromDir string
+		cfg     Config
+		repo    Repository
+		imports map[string]string
+		logg    *log.Logger  // writes to log
+		log     bytes.Buffer // buffered log
+		out     bytes.Buffer // buffered output (eg a diff)
+		status  string       // OK, SKIP or FAIL
+		changed bool         // a dry run or status found changes
+		done    chan struct{}
+
+		conflicts int // merge conflicts of manual edits
+	}
+	// dirFunc handles the dir of a task, for example dir or
+	// statusDir.
+	dirFunc func(t *task) error
+)
+
+// newTask returns a task for fromDir.
+func newTask(fromDir string, cfg Config, repo Repository,
+	imports map[string]string) *task {
+	t := &task{fromDir: fromDir, cfg: cfg, repo: repo, imports: imports,
+		done: make(chan struct{})}
+	t.logg = log.New(&t.log, "", 0)
+	return t
+}
+
+// runTasks handles the tasks with n workers. The log and output of
+// every task are printed as soon as it and all tasks before it are
+// done, followed by a summary. A failed task does not stop the
+// others, but is reported as an error at the end.
+func runTasks(tasks []*task, handle dirFunc, n int) error {
+	if n < 1 {
+		n = 1
+	}
+	queue := make(chan *task)
+	for i := 0; i < n; i++ {
+		go func() {
+			for t := range queue {
+				if err := handle(t); err != nil {
+					t.status = "FAIL"
+					t.logg.Printf("- FAIL: %s\n\n", err)
+				}
+				close(t.done)
+			}
+		}()
+	}
+	go func() {
+		for _, t := range tasks {
+			queue <- t
+		}
+		close(queue)
+	}()
+	failed := 0
+	for _, t := range tasks {
+		<-t.done
+		if t.log.Len() > 0 {
+			logg.Print(t.log.String())
+		}
+		fmt.Print(t.out.String())
+		if t.changed {
+			changed = true
+		}
+		if t.status == "FAIL" {
+			failed++
+		}
+	}
+	summary(tasks)
+	if failed > 0 {
+		return contextErr("%d of %d packages failed", failed, len(tasks))
+	}
+	return nil
+}
+
+// summary prints the status of every task with a status.
+func summary(tasks []*task) {
+	count := map[string]int{}
+	for _, t := range tasks {
+		if t.status == "" {
+			continue
+		}
+		if len(count) == 0 {
+			logg.Printf("Summary:\n")
+		}
+		count[t.status]++
+		_, fromRepo, toRepo := destination(t.fromDir, t.cfg, t.repo)
+		line := fmt.Sprintf("  %-4s  %s -> %s", t.status, fromRepo, toRepo)
+		if t.conflicts > 0 {
+			line += fmt.Sprintf(" (%d merge conflicts)", t.conflicts)
+		}
+		logg.Printf("%s\n", line)
+	}
+	if len(count) > 0 {
+		logg.Printf("  %d OK, %d SKIP, %d FAIL\n\n", count["OK"],
+			count["SKIP"], count["FAIL"])
+	}
+}
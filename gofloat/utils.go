@@ -1,104 +1,125 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/stanim/typewriter/packages"
 )
 
-// empty folder before converting (remove anything except hidden '.')
-func empty(dirname string) error {
-	_, err := os.Stat(dirname)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+// stagingDir creates a hidden staging folder next to toDir, so that
+// its files can be renamed into toDir. In a dry run it is a
+// temporary folder.
+func stagingDir(toDir string) (string, error) {
+	parent := ""
+	if !*dryRun {
+		parent = filepath.Dir(toDir)
+		if err := os.MkdirAll(parent, 0777); err != nil {
+			return "", context(err)
 		}
+	}
+	dirname, err := ioutil.TempDir(parent, ".gofloat-")
+	return dirname, context(err)
+}
+
+// commit moves the files and the pristine copies of the staging
+// folder into toDir and removes the files of toDir, which are not
+// staged (except hidden '.' files). The manifest is moved last. The
+// current files are moved to a backup folder first, so that they are
+// restored if a rename fails and toDir is never half updated.
+func commit(stageDir, toDir string) error {
+	staged, err := readFiles(stageDir)
+	if err != nil {
+		return err
+	}
+	current, err := readFiles(toDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(toDir, 0777); err != nil {
 		return context(err)
 	}
-	files, err := ioutil.ReadDir(dirname)
+	backupDir, err := ioutil.TempDir(filepath.Dir(toDir), ".gofloat-old-")
 	if err != nil {
 		return context(err)
 	}
-	for _, f := range files {
-		name := f.Name()
-		if name[0] == '.' { // skip .git etc ...
-			continue
+	defer os.RemoveAll(backupDir)
+	backup, err := moveFiles(toDir, backupDir,
+		append(sortedKeys(hashes(current)), pristineName, manifestName))
+	if err == nil {
+		var moved []string
+		moved, err = moveFiles(stageDir, toDir,
+			append(sortedKeys(hashes(staged)), pristineName, manifestName))
+		if err == nil {
+			return nil
 		}
-		name = filepath.Join(dirname, name)
-		if f.IsDir() {
-			if err := os.RemoveAll(name); err != nil {
-				return context(err)
-			}
+		moveFiles(toDir, stageDir, moved)
+	}
+	if _, rerr := moveFiles(backupDir, toDir, backup); rerr != nil {
+		return contextErr("%s (restore of %q failed: %s)", err, toDir,
+			rerr)
+	}
+	return err
+}
+
+// moveFiles renames files (or folders) from one folder to another and
+// returns the names of the moved ones, also if a rename fails. Missing
+// files (eg a manifest which does not exist yet) are skipped.
+func moveFiles(fromDir, toDir string, names []string) ([]string, error) {
+	var moved []string
+	for _, name := range names {
+		err := os.Rename(filepath.Join(fromDir, name),
+			filepath.Join(toDir, name))
+		if os.IsNotExist(err) {
 			continue
 		}
-		if err := os.Remove(name); err != nil {
-			return context(err)
+		if err != nil {
+			return moved, context(err)
 		}
+		moved = append(moved, name)
 	}
-	return nil
+	return moved, nil
 }
 
-// patch prepends the header, applies patches to the source and
-// appends footer.
-func patch(dirname string, header []byte, patches map[string][]Patch,
-	footer map[string][]byte) (int, error) {
-	if patches == nil {
-		return 0, nil
-	}
-	infos, err := ioutil.ReadDir(dirname)
+// copyUnmatched copies the go files of fromDir, which match none of
+// the builds (eg "//go:build ignore"), unchanged to toDir. It returns
+// their names.
+func copyUnmatched(fromDir, toDir string, builds []Build) ([]string,
+	error) {
+	names, err := filepath.Glob(filepath.Join(fromDir, "*.go"))
 	if err != nil {
-		return 0, err
+		return nil, context(err)
 	}
-	count := 0
-	for _, info := range infos {
-		if info.IsDir() {
-			continue
+	var unmatched []string
+	for _, filename := range names {
+		name := filepath.Base(filename)
+		matched := false
+		for _, b := range builds {
+			ok, err := b.context().MatchFile(fromDir, name)
+			if err != nil {
+				return nil, context(err)
+			}
+			matched = matched || ok
 		}
-		base := info.Name()
-		if strings.ToLower(filepath.Ext(base)) != ".go" {
+		if matched {
 			continue
 		}
-		filename := filepath.Join(dirname, base)
 		buf, err := ioutil.ReadFile(filename)
 		if err != nil {
-			return 0, context(err)
-		}
-		source := string(buf)
-		ps, ok := patches[base]
-		if ok {
-			for _, patch := range ps {
-				n := -1
-				if patch.N > 0 {
-					n = patch.N
-				}
-				source = source[:patch.Start] + strings.Replace(
-					source[patch.Start:], patch.Old, patch.New, n)
-			}
-			count += len(ps)
-		}
-		fo, err := os.Create(filename)
-		if err != nil {
-			return 0, context(err)
+			return nil, context(err)
 		}
-		defer fo.Close()
-		_, err = fo.Write(header)
-		if err != nil {
-			return 0, context(err)
-		}
-		_, err = fo.WriteString(source)
-		if err != nil {
-			return 0, context(err)
-		}
-		ft, ok := footer[base]
-		if ok {
-			_, err = fo.Write(ft)
-			if err != nil {
-				return 0, context(err)
-			}
+		if err := ioutil.WriteFile(filepath.Join(toDir, name), buf,
+			0666); err != nil {
+			return nil, context(err)
 		}
+		unmatched = append(unmatched, name)
 	}
-	return count, nil
+	return unmatched, nil
 }
 
 func hasSuffix(s string, suffix []string) bool {
@@ -124,10 +145,18 @@ func copyFiles(fromDir, toDir string, readme []byte) error {
 		if name[0] == '.' || hasSuffix(name, []string{".go", "~"}) {
 			continue
 		}
-		// copy with os.Link
+		// copy with os.Link (or copy the file to another device)
 		if !strings.HasPrefix(strings.ToLower(name), "readme") {
 			if err := os.Link(filepath.Join(fromDir, name),
-				filepath.Join(toDir, name)); err != nil {
+				filepath.Join(toDir, name)); err == nil {
+				continue
+			}
+			buf, err := ioutil.ReadFile(filepath.Join(fromDir, name))
+			if err != nil {
+				return context(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(toDir, name), buf,
+				f.Mode()); err != nil {
 				return context(err)
 			}
 			continue
@@ -153,3 +182,110 @@ func copyFiles(fromDir, toDir string, readme []byte) error {
 	}
 	return nil
 }
+
+// readFiles reads all files of a folder except hidden '.' files and
+// subfolders. A missing folder has no files.
+func readFiles(dirname string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	infos, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return files, nil
+		}
+		return nil, context(err)
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || name[0] == '.' {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(dirname, name))
+		if err != nil {
+			return nil, context(err)
+		}
+		files[name] = buf
+	}
+	return files, nil
+}
+
+// copyDir copies the files (without subfolders), the manifest and the
+// pristine copies of a folder to another folder.
+func copyDir(fromDir, toDir string) error {
+	files, err := readFiles(fromDir)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(fromDir, manifestName))
+	if err == nil {
+		files[manifestName] = buf
+	} else if !os.IsNotExist(err) {
+		return context(err)
+	}
+	for name, buf := range files {
+		if err := ioutil.WriteFile(filepath.Join(toDir, name), buf,
+			0666); err != nil {
+			return context(err)
+		}
+	}
+	// pristine copies of the generated files
+	pristine, err := readFiles(filepath.Join(fromDir, pristineName))
+	if err != nil || len(pristine) == 0 {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(toDir, pristineName),
+		0777); err != nil {
+		return context(err)
+	}
+	for name, buf := range pristine {
+		if err := ioutil.WriteFile(filepath.Join(toDir, pristineName,
+			name), buf, 0666); err != nil {
+			return context(err)
+		}
+	}
+	return nil
+}
+
+// diffDir returns a unified diff of the files (without subfolders)
+// of the old and new folder. Both are labeled as repo.
+func diffDir(oldDir, newDir, repo string) (string, error) {
+	oldFiles, err := readFiles(oldDir)
+	if err != nil {
+		return "", err
+	}
+	newFiles, err := readFiles(newDir)
+	if err != nil {
+		return "", err
+	}
+	names := []string{}
+	for name := range oldFiles {
+		names = append(names, name)
+	}
+	for name := range newFiles {
+		if _, ok := oldFiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		a, inOld := oldFiles[name]
+		b, inNew := newFiles[name]
+		aName, bName := "a/"+repo+"/"+name, "b/"+repo+"/"+name
+		if !inOld {
+			aName = "/dev/null"
+		}
+		if !inNew {
+			bName = "/dev/null"
+		}
+		if bytes.Equal(a, b) && inOld == inNew {
+			continue
+		}
+		if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+			fmt.Fprintf(&buf, "Binary files %s and %s differ\n", aName,
+				bName)
+			continue
+		}
+		buf.WriteString(packages.UnifiedDiff(aName, bName, a, b))
+	}
+	return buf.String(), nil
+}
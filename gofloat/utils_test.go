@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// commitDirs creates a destination and a staging folder with the
+// files (a name ending with "/" is a folder with a file) and returns
+// the parent folder.
+func commitDirs(t *testing.T, current, staged []string) string {
+	parent, err := ioutil.TempDir("", "gofloat-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dirname, names := range map[string][]string{"dst": current,
+		"stage": staged} {
+		for _, name := range append(names, manifestName,
+			pristineName+"/") {
+			filename := filepath.Join(parent, dirname, name, dirname)
+			if name[len(name)-1] != '/' {
+				filename = filepath.Join(parent, dirname, name)
+			}
+			if err := os.MkdirAll(filepath.Dir(filename),
+				0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filename, []byte(dirname),
+				0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return parent
+}
+
+// dirContents returns the contents of the files, the manifest and the
+// pristine folder in dirname by name.
+func dirContents(t *testing.T, dirname string) map[string]string {
+	files, err := readFiles(dirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for name, buf := range files {
+		contents[name] = string(buf)
+	}
+	for _, name := range []string{manifestName,
+		filepath.Join(pristineName, "dst"),
+		filepath.Join(pristineName, "stage")} {
+		if buf, err := ioutil.ReadFile(filepath.Join(dirname,
+			name)); err == nil {
+			contents[name] = string(buf)
+		}
+	}
+	return contents
+}
+
+func TestCommit(t *testing.T) {
+	parent := commitDirs(t, []string{"a.go", "old.go"},
+		[]string{"a.go", "b.go"})
+	defer os.RemoveAll(parent)
+	toDir := filepath.Join(parent, "dst")
+	want := dirContents(t, filepath.Join(parent, "stage"))
+	if err := commit(filepath.Join(parent, "stage"), toDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirContents(t, toDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestCommitRestore checks that the destination is restored if a
+// file can not be moved into it: b.go is a folder in the destination.
+func TestCommitRestore(t *testing.T) {
+	parent := commitDirs(t, []string{"a.go", "b.go/"},
+		[]string{"a.go", "b.go"})
+	defer os.RemoveAll(parent)
+	toDir := filepath.Join(parent, "dst")
+	want := dirContents(t, toDir)
+	if err := commit(filepath.Join(parent, "stage"), toDir); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := dirContents(t, toDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	infos, err := ioutil.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("backup folder is not removed: %d folders", len(infos))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// verifyCommands are the go commands, which verify a converted
+// package. (The build output is discarded, so that no binary ends up
+// between the generated files.)
+var verifyCommands = [][]string{
+	{"build", "-o", os.DevNull},
+	{"vet"},
+	{"test"},
+}
+
+// goCommand returns a go command, which runs in dirname as if it were
+// the package importPath: a temporary GOPATH in front of the GOPATH
+// links importPath to dirname, so that an external test package
+// imports the package in dirname (eg a staging folder) instead of the
+// destination. Modules are disabled (GO111MODULE=off), so that the
+// GOPATH is used, and not downloaded (GOPROXY=off). The returned
+// function removes the temporary GOPATH.
+func goCommand(dirname, importPath string, args ...string) (*exec.Cmd,
+	func(), error) {
+	tmp, err := ioutil.TempDir("", "gofloat-gopath-")
+	if err != nil {
+		return nil, nil, context(err)
+	}
+	remove := func() { os.RemoveAll(tmp) }
+	link := filepath.Join(tmp, "src", filepath.FromSlash(importPath))
+	if err := os.MkdirAll(filepath.Dir(link), 0777); err != nil {
+		remove()
+		return nil, nil, context(err)
+	}
+	if err := os.Symlink(dirname, link); err != nil {
+		remove()
+		return nil, nil, context(err)
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = link
+	gopath := tmp + string(os.PathListSeparator) + os.Getenv("GOPATH")
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "PWD="+link,
+		"GO111MODULE=off", "GOPROXY=off")
+	return cmd, remove, nil
+}
+
+// verify builds, vets and tests the package in dirname as importPath
+// with the local go tool. The output of the first failing command is
+// returned as failures. A folder without go files (eg the root of a
+// repository) is skipped.
+func verify(dirname, importPath string) (failures []string, err error) {
+	goFiles, err := filepath.Glob(filepath.Join(dirname, "*.go"))
+	if err != nil || len(goFiles) == 0 {
+		return nil, context(err)
+	}
+	for _, args := range verifyCommands {
+		cmd, remove, err := goCommand(dirname, importPath,
+			append(args, ".")...)
+		if err != nil {
+			return nil, err
+		}
+		out, err := cmd.CombinedOutput()
+		remove()
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, context(err) // go is not installed
+		}
+		failures = append(failures, "go "+args[0]+":")
+		for _, line := range strings.Split(strings.TrimSpace(string(out)),
+			"\n") {
+			failures = append(failures, "  "+line)
+		}
+		break
+	}
+	return failures, nil
+}
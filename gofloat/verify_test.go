@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePackage writes the files of a package in a temporary folder.
+func writePackage(t *testing.T, files map[string]string) string {
+	dirname, err := ioutil.TempDir("", "gofloat-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dirname, name),
+			[]byte(src), 0666); err != nil {
+			os.RemoveAll(dirname)
+			t.Fatal(err)
+		}
+	}
+	return dirname
+}
+
+// TestVerify runs the go commands on a package outside of the GOPATH,
+// which an external test package imports by its import path.
+func TestVerify(t *testing.T) {
+	const importPath = "example.com/half"
+	tests := []struct {
+		name    string
+		files   map[string]string
+		failure string // first line of the failures
+	}{
+		{name: "ok", files: map[string]string{
+			"half.go": "package half\n\nfunc Half(x float64) float64 " +
+				"{ return x / 2 }\n",
+			"half_test.go": `package half_test
+
+import (
+	"testing"
+
+	"example.com/half"
+)
+
+func TestHalf(t *testing.T) {
+	if half.Half(1) != 0.5 {
+		t.Fail()
+	}
+}
+`}},
+		{name: "no go files", files: map[string]string{
+			"README": "half\n"}},
+		{name: "build", failure: "go build:", files: map[string]string{
+			"half.go": "package half\n\nfunc Half(x float64) int " +
+				"{ return x / 2 }\n"}},
+		{name: "test", failure: "go test:", files: map[string]string{
+			"half.go": "package half\n\nfunc Half(x float64) float64 " +
+				"{ return x / 3 }\n",
+			"half_test.go": `package half_test
+
+import (
+	"testing"
+
+	"example.com/half"
+)
+
+func TestHalf(t *testing.T) {
+	if half.Half(1) != 0.5 {
+		t.Fail()
+	}
+}
+`}},
+	}
+	for _, test := range tests {
+		dirname := writePackage(t, test.files)
+		failures, err := verify(dirname, importPath)
+		os.RemoveAll(dirname)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got := ""
+		if len(failures) > 0 {
+			got = failures[0]
+		}
+		if got != test.failure {
+			t.Errorf("%s: got failures\n%s\nwant %q", test.name,
+				strings.Join(failures, "\n"), test.failure)
+		}
+	}
+}
+
+func TestRunMain(t *testing.T) {
+	dirname := writePackage(t, map[string]string{
+		"main.go": `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() { fmt.Println(os.Args[1:]) }
+`})
+	defer os.RemoveAll(dirname)
+	out, err := runMain(dirname, "example.com/cmd", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(out); got != "[a b]\n" {
+		t.Errorf("got %q, want %q", got, "[a b]\n")
+	}
+}
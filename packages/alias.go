@@ -0,0 +1,71 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// alias describes a named alias type (eg "type Number = float64"),
+// which is used in converted code instead of the literal target type,
+// so the numeric type can be changed in one place.
+type alias struct {
+	name string // "Number"
+	typ  string // "float64"
+}
+
+// SetAlias makes all packages use the alias name instead of typ in
+// converted code. The alias is declared in a generated file (see
+// Save). An empty name disables the alias.
+func (pkgs *Packages) SetAlias(name, typ string) {
+	for i := range *pkgs {
+		(*pkgs)[i].alias = alias{name: name, typ: typ}
+	}
+}
+
+// aliasFilename returns the base name of the generated alias file.
+// (For example "number.go" for "Number".)
+func (pkg *Package) aliasFilename() string {
+	return strings.ToLower(pkg.alias.name) + ".go"
+}
+
+// typeName returns the name which should be used in converted code
+// for typ: the alias name if typ is aliased, otherwise typ itself.
+func (pkg *Package) typeName(typ string) string {
+	if pkg.alias.name != "" && typ == pkg.alias.typ {
+		return pkg.alias.name
+	}
+	return typ
+}
+
+// realName returns the aliased type for the alias name, otherwise
+// name itself. This normalizes type names in type error messages.
+func (pkg *Package) realName(name string) string {
+	if pkg.alias.name != "" && name == pkg.alias.name {
+		return pkg.alias.typ
+	}
+	return name
+}
+
+// saveAlias saves the alias declaration in dirname. Test packages
+// share the declaration of the package under test.
+func (pkg *Package) saveAlias(dirname string) error {
+	if pkg.alias.name == "" || strings.HasSuffix(pkg.Name, "_test") {
+		return nil
+	}
+	fo, err := os.Create(filepath.Join(dirname, pkg.aliasFilename()))
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	var imports string
+	for _, imp := range pkg.operators[pkg.alias.typ].Imports {
+		imports += fmt.Sprintf("import %q\n\n", imp)
+	}
+	_, err = fmt.Fprintf(fo, "package %s\n\n%s"+
+		"// %s is the numeric type of this package.\n"+
+		"type %s = %s\n", pkg.Name, imports, pkg.alias.name,
+		pkg.alias.name, pkg.alias.typ)
+	return err
+}
@@ -0,0 +1,274 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxFuzz is the maximum number of context lines, which may be ignored
+// at the start and the end of a hunk (the same as GNU patch).
+const maxFuzz = 2
+
+type (
+	// FileDiff is the part of a unified diff, which changes one file.
+	FileDiff struct {
+		Old, New string // names in the header (without "a/" or "b/")
+		hunks    []diffHunk
+	}
+	// diffHunk is a hunk of a unified diff, which starts at the line a0
+	// (counted from 0) of the old file. Its lines start with ' ', '-'
+	// or '+'.
+	diffHunk struct {
+		header string // "@@ -3,7 +3,8 @@"
+		a0     int
+		lines  []string
+	}
+	// DiffResult is the result of applying a FileDiff.
+	DiffResult struct {
+		Applied  int
+		Moved    []string // hunks applied with an offset or fuzz
+		Rejected int
+		Rejects  string // the rejected hunks as unified diff
+	}
+)
+
+// ParseDiff parses a unified diff (for example of diff -u or git
+// diff) into the diffs of its files. Other lines (eg "diff --git" or
+// "index") are ignored.
+func ParseDiff(diff []byte) ([]FileDiff, error) {
+	lines := splitLines(string(diff))
+	var fds []FileDiff
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) &&
+			strings.HasPrefix(lines[i+1], "+++ "):
+			fds = append(fds, FileDiff{
+				Old: diffName(line[4:]),
+				New: diffName(lines[i+1][4:]),
+			})
+			i += 2
+		case strings.HasPrefix(line, "@@ "):
+			if len(fds) == 0 {
+				return nil, fmt.Errorf("line %d: hunk without file header",
+					i+1)
+			}
+			h, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", i+1, err)
+			}
+			fd := &fds[len(fds)-1]
+			fd.hunks = append(fd.hunks, h)
+			i += n
+		default:
+			i++
+		}
+	}
+	return fds, nil
+}
+
+// diffName returns the file name of a "---" or "+++" header without
+// timestamp and without the "a/" or "b/" prefix of git.
+func diffName(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if i := strings.Index(s, "\t"); i >= 0 {
+		s = s[:i]
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// parseHunk parses the hunk at the start of lines and returns the
+// number of lines it uses.
+func parseHunk(lines []string) (diffHunk, int, error) {
+	h := diffHunk{header: strings.TrimRight(lines[0], "\r\n")}
+	fields := strings.Fields(h.header)
+	if len(fields) < 4 || fields[3] != "@@" ||
+		!strings.HasPrefix(fields[1], "-") ||
+		!strings.HasPrefix(fields[2], "+") {
+		return h, 0, fmt.Errorf("invalid hunk header %q", h.header)
+	}
+	a0, oldCount, err := parseRange(fields[1][1:])
+	if err != nil {
+		return h, 0, err
+	}
+	_, newCount, err := parseRange(fields[2][1:])
+	if err != nil {
+		return h, 0, err
+	}
+	h.a0 = a0
+	n := 1
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if strings.HasPrefix(line, "\\") {
+			// "\ No newline at end of file"
+			if len(h.lines) > 0 {
+				last := &h.lines[len(h.lines)-1]
+				*last = strings.TrimSuffix(*last, "\n")
+			}
+			continue
+		}
+		if oldCount == 0 && newCount == 0 {
+			break
+		}
+		if line == "\n" {
+			line = " \n" // an empty context line without space
+		}
+		switch line[0] {
+		case ' ':
+			oldCount--
+			newCount--
+		case '-':
+			oldCount--
+		case '+':
+			newCount--
+		default:
+			return h, n, fmt.Errorf("unexpected line %q in hunk", line)
+		}
+		if oldCount < 0 || newCount < 0 {
+			return h, n, fmt.Errorf("hunk %q is too long", h.header)
+		}
+		h.lines = append(h.lines, line)
+	}
+	if oldCount > 0 || newCount > 0 {
+		return h, n, fmt.Errorf("hunk %q is incomplete", h.header)
+	}
+	return h, n, nil
+}
+
+// parseRange parses the range of a hunk header ("3,7" or "3") and
+// returns its first line (counted from 0) and its number of lines.
+func parseRange(s string) (int, int, error) {
+	count := 1
+	if i := strings.Index(s, ","); i >= 0 {
+		var err error
+		if count, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, fmt.Errorf("invalid range %q", s)
+		}
+		s = s[:i]
+	}
+	start, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if count > 0 {
+		start-- // an empty range starts after the line
+	}
+	return start, count, nil
+}
+
+// split returns the old and the new lines of a hunk without fuzz
+// context lines at its start and end. It returns the number of lines
+// which are left out at the start or false if there are not enough
+// context lines.
+func (h diffHunk) split(fuzz int) (old, new []string, lead int, ok bool) {
+	lead, trail := 0, 0
+	for lead < fuzz && lead < len(h.lines) && h.lines[lead][0] == ' ' {
+		lead++
+	}
+	for trail < fuzz && trail < len(h.lines)-lead &&
+		h.lines[len(h.lines)-1-trail][0] == ' ' {
+		trail++
+	}
+	if fuzz > 0 && lead < fuzz && trail < fuzz {
+		return nil, nil, 0, false // the same as less fuzz
+	}
+	for _, line := range h.lines[lead : len(h.lines)-trail] {
+		if line[0] != '+' {
+			old = append(old, line[1:])
+		}
+		if line[0] != '-' {
+			new = append(new, line[1:])
+		}
+	}
+	return old, new, lead, true
+}
+
+// locate finds the old lines of a hunk in lines, which are expected at
+// line want, but not before line min. It tries all positions from the
+// nearest to the farthest with increasing fuzz.
+func (h diffHunk) locate(lines []string, want, min int) (pos, fuzz int,
+	old, new []string, found bool) {
+	for fuzz = 0; fuzz <= maxFuzz; fuzz++ {
+		old, new, lead, ok := h.split(fuzz)
+		if !ok {
+			continue
+		}
+		max := len(lines) - len(old)
+		for d := 0; ; d++ {
+			below, above := want+lead-d, want+lead+d
+			if below < min && above > max {
+				break
+			}
+			if below >= min && below <= max && equalLines(lines[below:],
+				old) {
+				return below, fuzz, old, new, true
+			}
+			if d > 0 && above >= min && above <= max &&
+				equalLines(lines[above:], old) {
+				return above, fuzz, old, new, true
+			}
+		}
+	}
+	return 0, 0, nil, nil, false
+}
+
+// equalLines checks if lines starts with prefix.
+func equalLines(lines, prefix []string) bool {
+	for i, line := range prefix {
+		if lines[i] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply applies the hunks of a diff to src. Like GNU patch, a hunk is
+// also applied if its lines moved (offset) or if up to 2 lines of its
+// context at the start and end differ (fuzz). Hunks which can not be
+// applied are rejected.
+func (fd FileDiff) Apply(src []byte) ([]byte, DiffResult) {
+	var r DiffResult
+	lines := splitLines(string(src))
+	var rejects bytes.Buffer
+	delta, min := 0, 0
+	for i, h := range fd.hunks {
+		want := h.a0 + delta
+		pos, fuzz, old, new, ok := h.locate(lines, want, min)
+		if !ok {
+			r.Rejected++
+			rejects.WriteString(h.header + "\n")
+			for _, line := range h.lines {
+				writeLines(&rejects, line[:1], []string{line[1:]})
+			}
+			continue
+		}
+		_, _, lead, _ := h.split(fuzz)
+		offset := pos - lead - want
+		if offset != 0 || fuzz > 0 {
+			moved := fmt.Sprintf("hunk #%d succeeded at %d", i+1,
+				pos-lead+1)
+			if offset != 0 {
+				moved += fmt.Sprintf(" (offset %d lines)", offset)
+			}
+			if fuzz > 0 {
+				moved += fmt.Sprintf(" with fuzz %d", fuzz)
+			}
+			r.Moved = append(r.Moved, moved)
+		}
+		lines = append(lines[:pos:pos], append(new,
+			lines[pos+len(old):]...)...)
+		delta += offset + len(new) - len(old)
+		min = pos + len(new)
+		r.Applied++
+	}
+	if r.Rejected > 0 {
+		r.Rejects = fmt.Sprintf("--- %s\n+++ %s\n", fd.Old, fd.New) +
+			rejects.String()
+	}
+	return []byte(strings.Join(lines, "")), r
+}
@@ -0,0 +1,185 @@
+package packages
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/types"
+)
+
+// Cleanup removes redundant conversions in all packages.
+// See Package.Cleanup.
+func (pkgs *Packages) Cleanup() int {
+	count := 0
+	for i := range *pkgs {
+		count += (*pkgs)[i].Cleanup()
+	}
+	return count
+}
+
+// Cleanup removes redundant conversions, which are left by Fix, so
+// converted code reads like hand-written code:
+//
+//	int(i)                   -> i      (i is int)
+//	float64(int(float64(i))) -> float64(i)
+//	float64(5.0)             -> 5.0
+//
+// Literals are not changed, so that the original ones keep their
+// form. (Convert only adds a ".0" where it is needed, see
+// convertBasicLit.) It needs valid type information, so it should be called after
+// FixAll (or Recheck). It returns the number of removals. Call
+// Recheck before using the type information again.
+func (pkg *Package) Cleanup() int {
+	count := 0
+	for _, f := range pkg.Ast.Files {
+		cl := &cleaner{pkg: pkg}
+		astutil.Apply(f, nil, cl.post)
+		if cl.count > 0 {
+			pkg.changed[f] = struct{}{}
+		}
+		count += cl.count
+	}
+	return count
+}
+
+// cleaner removes redundant conversions of a single file.
+type cleaner struct {
+	pkg   *Package
+	count int
+}
+
+// post is called by astutil.Apply after the children of a node are
+// cleaned up.
+func (cl *cleaner) post(c *astutil.Cursor) bool {
+	switch n := c.Node().(type) {
+	case *ast.CallExpr:
+		if e := cl.conversion(n); e != nil {
+			c.Replace(e)
+		}
+	}
+	return true
+}
+
+// convType returns the type and the argument of a conversion such as
+// float64(x), otherwise ok is false.
+func (cl *cleaner) convType(e ast.Expr) (types.Type, ast.Expr, bool) {
+	call, ok := astutil.Unparen(e).(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 || call.Ellipsis != token.NoPos {
+		return nil, nil, false
+	}
+	tv, ok := cl.pkg.Info.Types[call.Fun]
+	if !ok || !tv.IsType() {
+		return nil, nil, false
+	}
+	return tv.Type, call.Args[0], true
+}
+
+// typeOf returns the type of an expression, also for conversions
+// which are changed by the cleaner itself.
+func (cl *cleaner) typeOf(e ast.Expr) types.Type {
+	if typ, _, ok := cl.convType(e); ok {
+		return typ
+	}
+	return cl.pkg.Info.TypeOf(e)
+}
+
+// isConstant checks if an expression has a constant value.
+func (cl *cleaner) isConstant(e ast.Expr) bool {
+	return cl.pkg.Info.Types[e].Value != nil
+}
+
+// conversion returns the replacement of a redundant conversion or nil.
+func (cl *cleaner) conversion(call *ast.CallExpr) ast.Expr {
+	typ, arg, ok := cl.convType(call)
+	if !ok {
+		return nil
+	}
+	// collapse nested conversions: T1(T2(x)) -> T1(x)
+	if typ2, arg2, ok := cl.convType(arg); ok && !cl.isConstant(arg2) {
+		if from := cl.typeOf(arg2); from != nil && preserving(from, typ2) {
+			call.Args[0] = arg2
+			arg = arg2
+			cl.count++
+		}
+	}
+	// identity conversion: T(x) -> x (x is T)
+	if !cl.isConstant(arg) {
+		if from := cl.typeOf(arg); from != nil && types.Identical(from, typ) {
+			cl.count++
+			return arg
+		}
+		return nil
+	}
+	// constant with T as default type: float64(5.0) -> 5.0
+	lit, ok := astutil.Unparen(arg).(*ast.BasicLit)
+	if !ok {
+		return nil
+	}
+	switch {
+	case lit.Kind == token.FLOAT && types.Identical(typ, types.Typ[types.Float64]),
+		lit.Kind == token.INT && types.Identical(typ, types.Typ[types.Int]):
+		cl.count++
+		return lit
+	}
+	return nil
+}
+
+// preserving checks if a conversion from one basic type to another
+// preserves all values. An integer only fits in the mantissa of a
+// float if it has at most 32 bits for float64 or 16 bits for float32
+// (so int64(float64(i)) is kept for an int i).
+func preserving(from, to types.Type) bool {
+	if types.Identical(from, to) {
+		return true
+	}
+	fb, ok := from.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	tb, ok := to.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	fi, ti := fb.Info(), tb.Info()
+	fs, ts := basicSize[fb.Kind()], basicSize[tb.Kind()]
+	switch {
+	case fi&types.IsInteger != 0 && ti&types.IsInteger != 0:
+		fu, tu := fi&types.IsUnsigned != 0, ti&types.IsUnsigned != 0
+		switch {
+		case fu == tu:
+			return ts >= fs
+		case fu && !tu:
+			return ts > fs
+		}
+		return false
+	case fi&types.IsInteger != 0 && ti&types.IsFloat != 0:
+		if fs == 0 { // uintptr
+			return false
+		}
+		if tb.Kind() == types.Float64 {
+			return fs <= 32
+		}
+		return fs <= 16
+	case fi&types.IsFloat != 0 && ti&types.IsFloat != 0:
+		return ts >= fs
+	}
+	return false
+}
+
+// basicSize gives the size in bits of basic numeric types.
+// (int and uint are assumed to be 64 bits.)
+var basicSize = map[types.BasicKind]int{
+	types.Int:     64,
+	types.Int8:    8,
+	types.Int16:   16,
+	types.Int32:   32,
+	types.Int64:   64,
+	types.Uint:    64,
+	types.Uint8:   8,
+	types.Uint16:  16,
+	types.Uint32:  32,
+	types.Uint64:  64,
+	types.Float32: 32,
+	types.Float64: 64,
+}
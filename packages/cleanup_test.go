@@ -0,0 +1,22 @@
+package packages
+
+import "testing"
+
+// TestCleanup checks that redundant conversions are removed, but
+// literals keep their original form.
+func TestCleanup(t *testing.T) {
+	pkg, cleanup := testPackage(t, `package p
+
+func f(x float64, i int) float64 {
+	return x*2.0 + float64(int(i))
+}
+`)
+	defer cleanup()
+	if n := pkg.Cleanup(); n != 1 {
+		t.Errorf("got %d removals, want 1", n)
+	}
+	stmts := firstStmts(t, pkg)
+	if got, want := stmts["f"], "return x*2.0 + float64(i)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
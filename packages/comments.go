@@ -0,0 +1,103 @@
+package packages
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LostComments compares the comments of the go files in two
+// directories, for example before and after a conversion. Every
+// comment is attached to its top level declaration by an
+// ast.CommentMap, so comments which are dropped or which moved to
+// another declaration are reported as "file.go: func f: // comment".
+// Only files which exist in both directories are compared.
+func LostComments(fromDir, toDir string) ([]string, error) {
+	fromFiles, err := filepath.Glob(filepath.Join(fromDir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var lost []string
+	for _, fromFile := range fromFiles {
+		toFile := filepath.Join(toDir, filepath.Base(fromFile))
+		if _, err := os.Stat(toFile); os.IsNotExist(err) {
+			continue
+		}
+		from, err := fileComments(fromFile)
+		if err != nil {
+			return nil, err
+		}
+		to, err := fileComments(toFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, n := range from {
+			for i := to[key]; i < n; i++ {
+				lost = append(lost, fmt.Sprintf("%s: %s",
+					filepath.Base(fromFile), key))
+			}
+		}
+	}
+	sort.Strings(lost)
+	return lost, nil
+}
+
+// fileComments counts the comments of a file by their declaration
+// and text ("func f: // comment").
+func fileComments(filename string) (map[string]int, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	comments := map[string]int{}
+	for node, groups := range ast.NewCommentMap(fset, f, f.Comments) {
+		decl := declName(fset, f, node)
+		for _, group := range groups {
+			for _, c := range group.List {
+				comments[decl+": "+c.Text]++
+			}
+		}
+	}
+	return comments, nil
+}
+
+// declName returns the name of the top level declaration which
+// contains node, eg "func (*SVG) Circle", "type SVG" or "package".
+func declName(fset *token.FileSet, f *ast.File, node ast.Node) string {
+	for _, decl := range f.Decls {
+		if node.Pos() < decl.Pos() || node.End() > decl.End() {
+			continue
+		}
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv, _ := str(fset, d.Recv.List[0].Type)
+				name = "(" + recv + ") " + name
+			}
+			return "func " + name
+		case *ast.GenDecl:
+			names := []string{}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.ImportSpec:
+					names = append(names, s.Path.Value)
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, ident := range s.Names {
+						names = append(names, ident.Name)
+					}
+				}
+			}
+			return d.Tok.String() + " " + strings.Join(names, ", ")
+		}
+	}
+	return "package"
+}
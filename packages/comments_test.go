@@ -0,0 +1,69 @@
+package packages
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLostComments converts the corpus in testdata/comments from int
+// to float64 (as gofloat does) and checks that no comment is lost or
+// moved.
+func TestLostComments(t *testing.T) {
+	fromDir := filepath.Join("testdata", "comments")
+	toDir, err := ioutil.TempDir("", "commentsf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(toDir)
+	types := TypeMap{"int": "float64"}
+	// convert
+	pkgs, err := NewContext(fromDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs.SetOutput(ioutil.Discard)
+	if err := pkgs.Error(); err != nil {
+		t.Fatal(err)
+	}
+	skip := NewSkip(map[string][]string{"*": {"i"}})
+	if err := pkgs.Convert(types, toDir, skip,
+		map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkgs.Save(toDir); err != nil {
+		t.Fatal(err)
+	}
+	// fix, clean up and format in memory
+	pkgs, err = NewContext(toDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs.SetOutput(ioutil.Discard)
+	_, remaining, err := pkgs.FixAll(types, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range remaining {
+		t.Errorf("unfixed type conflict: %s", err)
+	}
+	if pkgs.Cleanup() > 0 {
+		if err := pkgs.Recheck(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pkgs.Format(types, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkgs.Save(toDir); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := LostComments(fromDir, toDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range lost {
+		t.Errorf("lost or moved: %s", c)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"go/ast"
 	"go/token"
 	"strings"
+
+	"golang.org/x/tools/go/types"
 )
 
 // kind used by genDecl
@@ -13,6 +15,7 @@ var kind = map[string]token.Token{
 	"int16":   token.INT,
 	"int32":   token.INT,
 	"int64":   token.INT,
+	"uint":    token.INT,
 	"uint8":   token.INT,
 	"uint16":  token.INT,
 	"uint32":  token.INT,
@@ -21,12 +24,44 @@ var kind = map[string]token.Token{
 	"float64": token.FLOAT,
 }
 
+// defaultType maps the kind of a literal to the type of a variable,
+// which it defines (eg "s := 0.0").
+var defaultType = map[token.Token]string{
+	token.INT:   "int",
+	token.FLOAT: "float64",
+}
+
+// randName maps integer types to math/rand functions, which have
+// other names than the type (rand.Int -> rand.Int63).
+var randName = map[string]string{
+	"int32":  "Int31",
+	"int64":  "Int63",
+	"uint32": "Uint32",
+	"uint64": "Uint64",
+}
+
+// randNameN maps integer types to the math/rand version of Intn.
+var randNameN = map[string]string{
+	"int32": "Int31n",
+	"int64": "Int63n",
+}
+
+// bitSize returns the bit size argument of the strconv.Parse*
+// functions for a numeric type ("0" for int and uint).
+func bitSize(typ string) string {
+	size := strings.TrimLeft(typ, "uintfloat")
+	if size == "" {
+		return "0"
+	}
+	return size
+}
+
 // Convert source code of all packages from one type to another
 // and save the converted files in toDir.
-func (pkgs *Packages) Convert(fromType, toType, toDir string,
+func (pkgs *Packages) Convert(types TypeMap, toDir string,
 	skip Skip, imports map[string]string) error {
-	for _, pkg := range *pkgs {
-		if err := pkg.Convert(fromType, toType, toDir, skip,
+	for i := range *pkgs {
+		if err := (*pkgs)[i].Convert(types, toDir, skip,
 			imports); err != nil {
 			return err
 		}
@@ -36,24 +71,27 @@ func (pkgs *Packages) Convert(fromType, toType, toDir string,
 
 // Convert source code of a package from one type to another
 // and save the converted files in toDir.
-func (pkg *Package) Convert(fromType, toType, toDir string, skip Skip,
+func (pkg *Package) Convert(types TypeMap, toDir string, skip Skip,
 	imports map[string]string) error {
-	return pkg.Walk(newConvertor(pkg, fromType, toType, toDir, skip,
-		imports))
+	if err := pkg.Walk(newConvertor(pkg, types, toDir, skip,
+		imports)); err != nil {
+		return err
+	}
+	pkg.addOperatorImports(types)
+	return nil
 }
 
 // identConvertor converts all ast.Ident from one type to another.
 type identConvertor struct {
-	fromType string
-	toType   string
+	names map[string]string // fromType -> toType (or its alias)
 }
 
 // Visit implements the ast.Visitor interface.
 func (in identConvertor) Visit(node ast.Node) ast.Visitor {
 	switch x := node.(type) {
 	case *ast.Ident:
-		if x.Name == in.fromType {
-			x.Name = in.toType
+		if to, ok := in.names[x.Name]; ok {
+			x.Name = to
 		}
 	}
 	return in
@@ -63,25 +101,33 @@ func (in identConvertor) Visit(node ast.Node) ast.Visitor {
 // (convertor is an implementation of the Visitor interface.)
 type convertor struct {
 	pkg      *Package
-	fromType string
-	toType   string
+	types    TypeMap
+	names    map[string]string // fromType -> toType (or its alias)
 	skip     Skip
 	err      error
 	fromRepo string
 	toRepo   string
 	imports  map[string]string
+
+	quantized map[ast.Node]struct{} // see quantizeLit
 }
 
 // newConvertor creates a new convertor.
-func newConvertor(pkg *Package, fromType, toType, toDir string,
+func newConvertor(pkg *Package, types TypeMap, toDir string,
 	skip Skip, imports map[string]string) *convertor {
+	names := map[string]string{}
+	for from, to := range types {
+		names[from] = pkg.typeName(to)
+	}
 	return &convertor{
-		pkg:      pkg,
-		fromType: fromType,
-		toType:   toType,
-		skip:     skip,
-		err:      nil,
-		imports:  imports}
+		pkg:     pkg,
+		types:   types,
+		names:   names,
+		skip:    skip,
+		err:     nil,
+		imports: imports,
+
+		quantized: map[ast.Node]struct{}{}}
 }
 
 // Error implements the visit.Visitor interface
@@ -93,7 +139,15 @@ func (c *convertor) Error() error {
 func (c *convertor) Visit(node ast.Node) ast.Visitor {
 	switch n := node.(type) {
 	case *ast.AssignStmt:
+		c.rescaleAssign(n)
 		c.assignStmt(n)
+	case *ast.BasicLit:
+		// constants of a fixed-point type are scaled everywhere
+		if c.scaled(n) {
+			c.quantizeLit(n)
+		}
+	case *ast.BinaryExpr:
+		c.binaryExpr(n)
 	case *ast.CallExpr:
 		c.callExpr(n)
 	case *ast.FieldList:
@@ -113,17 +167,69 @@ func (c *convertor) Visit(node ast.Node) ast.Visitor {
 	return c
 }
 
-// convertBasicLit converts literals, for examle "5" to "5.0".
+// literalType returns the source type of a literal, which is in the
+// type map. Untyped constants get the first source type of the same
+// kind.
+func (c *convertor) literalType(lit *ast.BasicLit) (string, bool) {
+	if typ := c.pkg.Info.TypeOf(lit); typ != nil {
+		if _, ok := c.types[typ.Underlying().String()]; ok {
+			return typ.Underlying().String(), true
+		}
+		if basic, ok := typ.(*types.Basic); ok &&
+			basic.Info()&types.IsUntyped == 0 {
+			return "", false
+		}
+	}
+	for _, from := range c.types.From() {
+		if kind[from] == lit.Kind {
+			return from, true
+		}
+	}
+	return "", false
+}
+
+// convertBasicLit converts literals, for examle "5" to "5.0" or in
+// a reverse conversion "2.5" to "2". Only a literal which defines the
+// type of a variable or constant (or of an interface value) needs to
+// be converted (eg "s := 0.0"), otherwise the type is defined by the
+// context (eg "x = 5" for a float x). A conversion to another type than the default type of the
+// literal is explicit: "int64(0)" or "float32(0)".
 // (This is used by assignStmt and genDecl.)
-func (c *convertor) convertBasicLit(expr ast.Expr) ast.Expr {
+func (c *convertor) convertBasicLit(expr ast.Expr, define bool) ast.Expr {
 	basicLit, ok := expr.(*ast.BasicLit)
-	fk, fok := kind[c.fromType]
-	tk, tok := kind[c.toType]
-	if !ok || !fok || !tok || basicLit.Kind != fk {
+	if !ok {
+		return expr
+	}
+	fromType, ok := c.literalType(basicLit)
+	if !ok {
 		return expr
 	}
-	if fk == token.INT && tk == token.FLOAT {
-		basicLit.Value += ".0"
+	toType := c.types[fromType]
+	if basicLit.Kind == kind[fromType] {
+		if lit := c.literalRule(toType, basicLit); lit != nil {
+			return lit
+		}
+	}
+	if isReverse(fromType, toType) {
+		// with a scale also integer literals are quantized
+		if basicLit.Kind == token.FLOAT || (basicLit.Kind == token.INT &&
+			c.pkg.reverse.scale() > 1) {
+			c.quantizeLit(basicLit)
+		}
+	} else {
+		fk := kind[fromType]
+		tk, tok := kind[toType]
+		if !tok || basicLit.Kind != fk {
+			return expr
+		}
+		if fk == token.INT && tk == token.FLOAT && define &&
+			toType == defaultType[token.FLOAT] {
+			basicLit.Kind = token.FLOAT
+			basicLit.Value += ".0"
+		}
+	}
+	if define && toType != defaultType[basicLit.Kind] {
+		return convert(basicLit, c.pkg.typeName(toType))
 	}
 	return basicLit
 }
@@ -148,6 +254,17 @@ func (c *convertor) skipVar(name string) bool {
 	return c.skip.CheckSuffix(name, "|var")
 }
 
+// isInterface checks if an expression (or a type) is an interface,
+// so that a literal assigned to it keeps its own type.
+func (c *convertor) isInterface(e ast.Expr) bool {
+	typ := c.pkg.Info.TypeOf(e)
+	if typ == nil {
+		return false
+	}
+	_, ok := typ.Underlying().(*types.Interface)
+	return ok
+}
+
 // assignStmt converts variable assignment eg "a:=5" to "a:=5.0"
 func (c *convertor) assignStmt(as *ast.AssignStmt) {
 	for i, rh := range as.Rhs {
@@ -157,17 +274,11 @@ func (c *convertor) assignStmt(as *ast.AssignStmt) {
 		}
 		switch x := rh.(type) {
 		case *ast.BasicLit:
-			fk, fok := kind[c.fromType]
-			tk, tok := kind[c.toType]
-			if !ok || !fok || !tok || x.Kind != fk {
-				break
-			}
-			if fk == token.INT && tk == token.FLOAT {
-				x.Value += ".0"
-			}
+			as.Rhs[i] = c.convertBasicLit(x, as.Tok == token.DEFINE ||
+				c.isInterface(as.Lhs[i]))
 		case *ast.CompositeLit:
 			if x.Type != nil {
-				ast.Walk(identConvertor{fromType: c.fromType, toType: c.toType}, x.Type)
+				ast.Walk(identConvertor{c.names}, x.Type)
 			}
 		}
 	}
@@ -177,69 +288,141 @@ func (c *convertor) assignStmt(as *ast.AssignStmt) {
 func (c *convertor) callExpr(ce *ast.CallExpr) {
 	switch fun := ce.Fun.(type) {
 	case *ast.Ident:
-		switch fun.Name {
-		case c.fromType:
-			fun.Name = c.toType
-		case "make":
-			ast.Walk(identConvertor{fromType: c.fromType, toType: c.toType}, ce.Args[0])
+		if to, ok := c.names[fun.Name]; ok {
+			fun.Name = to
+			if strings.HasPrefix(to, "*") {
+				c.pointerConversion(ce, fun)
+			}
+		} else if fun.Name == "make" {
+			ast.Walk(identConvertor{c.names}, ce.Args[0])
 		}
 	case *ast.SelectorExpr:
 		var x string
 		if ident, ok := fun.X.(*ast.Ident); ok {
 			x = ident.Name
 		}
-		switch fun.Sel.Name {
-		case strings.Title(c.fromType):
-			if x == "flag" || x == "rand" {
-				fun.Sel.Name = strings.Title(c.toType)
-			}
-		case strings.Title(c.fromType) + "Var":
-			if x == "flag" || x == "rand" {
-				fun.Sel.Name = strings.Title(c.toType) + "Var"
-			}
-		case "Intn":
-			if x == "rand" {
-				fun.Sel.Name = strings.Title(c.toType) + "()*"
-				/*
-					if c.pkg.TypeStringOf(ce.Args[0]) != c.toType {
-						ce.Args[0] = convert(ce.Args[0], c.toType)
-					}*/
+		for _, from := range c.types.From() {
+			if c.callSelector(ce, fun, x, from) {
+				return
 			}
-		case "Atoi":
-			if x == "strconv" && c.fromType == "int" {
-				fun.Sel.Name = "ParseFloat"
-				var value string
-				switch c.toType {
-				case "float64":
-					value = "64"
-				case "float32":
-					value = "32"
-				default:
+		}
+	}
+}
+
+// pointerConversion fixes the conversion to a pointer type, eg
+// *big.Float(x), which would dereference a call. Values of another
+// type are converted with the Literal rule of the operators
+// (big.NewFloat(x)), otherwise the type is parenthesized
+// ((*big.Float)(x)).
+func (c *convertor) pointerConversion(ce *ast.CallExpr, fun *ast.Ident) {
+	if len(ce.Args) == 1 {
+		typ := c.pkg.Info.TypeOf(ce.Args[0])
+		if typ != nil {
+			if _, ok := c.types[typ.Underlying().String()]; !ok {
+				lit, ok := c.literalRule(fun.Name, ce.Args[0]).(*ast.CallExpr)
+				if ok {
+					ce.Fun, ce.Args = lit.Fun, lit.Args
 					return
 				}
-				ce.Args = append(ce.Args,
-					&ast.BasicLit{Kind: token.INT, Value: value})
-				if c.toType == "float32" {
-					ce.Fun = &ast.Ident{Name: "float32"}
-					ce.Args = []ast.Expr{&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   &ast.Ident{Name: "strconv"},
-							Sel: &ast.Ident{Name: "ParseFloat"},
-						},
-						Args: ce.Args,
-					}}
-				}
 			}
 		}
 	}
+	ce.Fun = &ast.ParenExpr{Lparen: fun.Pos(), X: fun}
+}
+
+// callSelector changes calls of package functions for the type
+// fromType, eg flag.Int->flag.Float64. It returns true if the call
+// is changed.
+func (c *convertor) callSelector(ce *ast.CallExpr, fun *ast.SelectorExpr,
+	x, fromType string) bool {
+	toType := c.types[fromType]
+	switch fun.Sel.Name {
+	case strings.Title(fromType):
+		if x == "flag" || x == "rand" {
+			fun.Sel.Name = strings.Title(toType)
+		}
+		if name, ok := randName[toType]; ok && x == "rand" {
+			fun.Sel.Name = name
+		}
+	case strings.Title(fromType) + "Var":
+		if x == "flag" || x == "rand" {
+			fun.Sel.Name = strings.Title(toType) + "Var"
+		}
+	case "Intn":
+		if x != "rand" || fromType != "int" {
+			return false
+		}
+		switch kind[toType] {
+		case token.INT:
+			if name, ok := randNameN[toType]; ok {
+				fun.Sel.Name = name
+			}
+		case token.FLOAT:
+			fun.Sel.Name = strings.Title(toType) + "()*"
+			/*
+				if c.pkg.TypeStringOf(ce.Args[0]) != toType {
+					ce.Args[0] = convert(ce.Args[0], toType)
+				}*/
+		}
+	case "Atoi":
+		if x != "strconv" || fromType != "int" {
+			return false
+		}
+		if kind[toType] == token.INT {
+			c.atoiInt(ce, fun, toType)
+			break
+		}
+		if toType == "float64" || toType == "float32" {
+			fun.Sel.Name = "ParseFloat"
+			ce.Args = append(ce.Args,
+				&ast.BasicLit{Kind: token.INT, Value: bitSize(toType)})
+			if toType == "float32" {
+				ce.Fun = &ast.Ident{Name: c.names[fromType]}
+				ce.Args = []ast.Expr{&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   &ast.Ident{Name: "strconv"},
+						Sel: &ast.Ident{Name: "ParseFloat"},
+					},
+					Args: ce.Args,
+				}}
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// atoiInt changes strconv.Atoi for integer types, for example
+// strconv.Atoi(s) -> strconv.ParseInt(s, 10, 64)
+func (c *convertor) atoiInt(ce *ast.CallExpr, fun *ast.SelectorExpr,
+	toType string) {
+	switch toType {
+	case "int":
+		return
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		fun.Sel.Name = "ParseUint"
+	default:
+		fun.Sel.Name = "ParseInt"
+	}
+	ce.Args = append(ce.Args,
+		&ast.BasicLit{Kind: token.INT, Value: "10"},
+		&ast.BasicLit{Kind: token.INT, Value: bitSize(toType)})
 }
 
 // appendField appends idents as typstr to lst
-func appendField(lst []*ast.Field, idents []*ast.Ident,
+func appendField(lst []*ast.Field, field *ast.Field, idents []*ast.Ident,
 	typstr map[bool]string, skip bool, kind,
 	arrayLen string) []*ast.Field {
-	var typ ast.Expr
-	typ = &ast.Ident{Name: typstr[skip]}
+	// keep the comments and the tag of the original field: the doc
+	// belongs to the first part and the line comment to the last part
+	first := idents[0] == field.Names[0]
+	last := idents[len(idents)-1] == field.Names[len(field.Names)-1]
+	ident := &ast.Ident{Name: typstr[skip]}
+	if last {
+		ident.NamePos = field.Type.Pos()
+	}
+	var typ ast.Expr = ident
 	if kind == "array" {
 		if arrayLen == "" {
 			typ = &ast.ArrayType{Elt: typ}
@@ -247,9 +430,17 @@ func appendField(lst []*ast.Field, idents []*ast.Ident,
 			typ = &ast.ArrayType{Elt: typ, Len: &ast.BasicLit{Value: arrayLen}}
 		}
 	}
-	return append(lst, &ast.Field{
+	f := &ast.Field{
 		Names: idents,
-		Type:  typ})
+		Type:  typ,
+		Tag:   field.Tag}
+	if first {
+		f.Doc = field.Doc
+	}
+	if last {
+		f.Comment = field.Comment
+	}
+	return append(lst, f)
 }
 
 // fieldlist converts a field list and spits it up by fromType and
@@ -282,18 +473,19 @@ func (c *convertor) fieldList(fieldList *ast.FieldList) {
 			continue
 		}
 
-		if identType.Name != c.fromType {
+		toName, ok := c.names[identType.Name]
+		if !ok {
 			lst = append(lst, field)
 			continue
 		}
 		if field.Names == nil {
-			identType.Name = c.toType
+			identType.Name = toName
 			lst = append(lst, field)
 			continue
 		}
 		typstr := map[bool]string{
-			true:  c.fromType,
-			false: c.toType}
+			true:  identType.Name,
+			false: toName}
 		prev := []*ast.Ident{field.Names[0]}
 		prevSkip := c.skipField(prev[0].Name)
 		for _, ident := range field.Names[1:] {
@@ -301,13 +493,15 @@ func (c *convertor) fieldList(fieldList *ast.FieldList) {
 			if skip == prevSkip {
 				prev = append(prev, ident)
 			} else {
-				lst = appendField(lst, prev, typstr, prevSkip, kind, arrayLen)
+				lst = appendField(lst, field, prev, typstr, prevSkip,
+					kind, arrayLen)
 				prev = []*ast.Ident{ident}
 				prevSkip = skip
 			}
 		}
 		if len(prev) != 0 {
-			lst = appendField(lst, prev, typstr, prevSkip, kind, arrayLen)
+			lst = appendField(lst, field, prev, typstr, prevSkip, kind,
+				arrayLen)
 		}
 	}
 	fieldList.List = lst
@@ -321,9 +515,9 @@ func (c *convertor) genDecl(gd *ast.GenDecl) {
 			if c.skipType(s.Name.Name) {
 				return
 			}
-			ast.Walk(identConvertor{c.fromType, c.toType}, s.Type)
+			ast.Walk(identConvertor{c.names}, s.Type)
 		case *ast.ValueSpec:
-			fromTo := identConvertor{c.fromType, c.toType}
+			fromTo := identConvertor{c.names}
 			for _, expr := range s.Values {
 				switch value := expr.(type) {
 				case *ast.CompositeLit:
@@ -335,7 +529,8 @@ func (c *convertor) genDecl(gd *ast.GenDecl) {
 			}
 			if gd.Tok == token.VAR || gd.Tok == token.CONST {
 				for j, val := range s.Values {
-					s.Values[j] = c.convertBasicLit(val)
+					s.Values[j] = c.convertBasicLit(val, s.Type == nil ||
+						c.isInterface(s.Type))
 				}
 			}
 			gd.Specs[i] = s
@@ -0,0 +1,58 @@
+package packages
+
+import "testing"
+
+// TestConvertDefine checks that a variable, which is defined by a
+// literal, gets the converted type.
+func TestConvertDefine(t *testing.T) {
+	src := `package p
+
+func Sum(xs []int) int {
+	s := 0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+var n int
+
+func Reset(m map[string]interface{}) {
+	n = 0
+	m["n"] = 0
+}
+`
+	tests := []struct {
+		toType, lit string
+	}{
+		{"float64", "0.0"},
+		{"float32", "float32(0)"},
+		{"int64", "int64(0)"},
+	}
+	// the type of n defines the type of 0, but not the interface
+	for _, test := range tests {
+		types := TypeMap{"int": test.toType}
+		pkgs, cleanup := convertSource(t, src, types, func(*Packages) {})
+		want := `package p
+
+func Sum(xs []` + test.toType + `) ` + test.toType + ` {
+	s := ` + test.lit + `
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+var n ` + test.toType + `
+
+func Reset(m map[string]interface{}) {
+	n = 0
+	m["n"] = ` + test.lit + `
+}
+`
+		if got := fixSource(t, pkgs, types); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.toType, got, want)
+		}
+		cleanup()
+	}
+}
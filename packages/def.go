@@ -18,7 +18,9 @@ During this phase no errors should occur.
 Fix
 
 Fix type conflicts. If an error occur during this phase, the command
-(e.g. gofloat) should quit immediately.
+(e.g. gofloat) should quit immediately. FixAll repeats Fix in memory
+(see Recheck) until no conflicts are left and returns the conflicts,
+which could not be fixed.
 
 Format
 
@@ -30,13 +32,18 @@ occurs during this phase, the package should be skipped.
 package packages
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 
 	"golang.org/x/tools/go/ast/astutil"
@@ -63,7 +70,19 @@ type (
 		Fset     *token.FileSet
 		Info     *types.Info
 		Errors   []error
+		alias    alias
+		reverse  Reverse
+		output   io.Writer // see SetOutput
+		lossy    []Lossy
+		changed  map[*ast.File]struct{} // files changed in memory
+		sources  map[string][]byte      // original source by filename
 		snippets Set
+		added    bool // snippets added since last check
+		// an external test package imports the package under test
+		importPath string         // see SetImportPath
+		tested     *types.Package // from memory
+		// operators of non-primitive target types
+		operators map[string]Operators // see SetOperators
 	}
 	// Packages is a collection of Package in the same directory.
 	// (For example "foo" and "foo_test".)
@@ -80,7 +99,10 @@ type (
 // AddSnippet permits to add necessary code for the conversion to
 // a package. (For example "i64" to convert float64 constants to int.)
 func (pkg *Package) AddSnippet(name string) {
-	pkg.snippets[name] = struct{}{}
+	if _, ok := pkg.snippets[name]; !ok {
+		pkg.snippets[name] = struct{}{}
+		pkg.added = true
+	}
 }
 
 // File returns the ast.File which contains pos.
@@ -108,9 +130,9 @@ func (pkg *Package) printPath(path []ast.Node) {
 		}
 	}
 	source, _ := str(pkg.Fset, path[0])
-	fmt.Printf("\t%q [%s]\n", source, typStr)
+	fmt.Fprintf(pkg.out(), "\t%q [%s]\n", source, typStr)
 	for i, n := range path {
-		fmt.Printf("\t%d: %s [%v] @ %d\n", i,
+		fmt.Fprintf(pkg.out(), "\t%d: %s [%v] @ %d\n", i,
 			astutil.NodeDescription(n), reflect.TypeOf(n), n.Pos())
 	}
 }
@@ -133,62 +155,86 @@ func (pkg *Package) TypeStringOf(e ast.Expr) string {
 // Save the package go files to another dir.
 func (pkg *Package) Save(dirname string) error {
 	pkgDir := pkg.Path()
-	for _, f := range pkg.Ast.Files {
-		filename := strings.Replace(Filename(pkg.Fset, f), pkgDir, dirname, 1)
-		if err := SaveFile(pkg.Fset, f, filename); err != nil {
+	for fn, f := range pkg.Ast.Files {
+		src, err := pkg.source(fn, f)
+		if err != nil {
+			return err
+		}
+		filename := strings.Replace(fn, pkgDir, dirname, 1)
+		if err := ioutil.WriteFile(filename, src, 0666); err != nil {
 			return err
 		}
 	}
 	if err := pkg.saveSnippets(); err != nil {
 		return err
 	}
+	if err := pkg.saveAlias(dirname); err != nil {
+		return err
+	}
 	return nil
 }
 
-// saveSnippets saves the package snippets in a "snippets.go" file,
-// which gets added to the package.
-func (pkg *Package) saveSnippets() error {
-	if len(pkg.snippets) > 0 {
-		fo, err := os.Create(filepath.Join(pkg.Path(), "snippets.go"))
-		if err != nil {
-			return err
-		}
-		_, err = fo.WriteString(fmt.Sprintf("package %s\n", pkg.Name))
-		if err != nil {
-			return err
-		}
-		// collect & write imports
-		var imports Set
-		for s := range pkg.snippets {
-			for _, imp := range snippets[s].imports {
-				imports[imp] = struct{}{}
-			}
+// snippetsFilename returns the filename of the snippets file.
+func (pkg *Package) snippetsFilename() string {
+	return filepath.Join(pkg.Path(), "snippets.go")
+}
+
+// snippetsSource returns the source of the "snippets.go" file with
+// the package snippets.
+func (pkg *Package) snippetsSource() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n", pkg.Name)
+	names := make([]string, 0, len(pkg.snippets))
+	for s := range pkg.snippets {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	// collect & write imports
+	imports := Set{}
+	for _, s := range names {
+		for _, imp := range snippets[s].imports {
+			imports[imp] = struct{}{}
 		}
-		if len(imports) > 0 {
-			_, err = fo.WriteString("import (\n")
-			if err != nil {
-				return err
-			}
-			for imp := range imports {
-				_, err = fo.WriteString(fmt.Sprintf("\t%q\n", imp))
-				if err != nil {
-					return err
-				}
-			}
-			_, err = fo.WriteString(")\n")
-			if err != nil {
-				return err
-			}
+	}
+	if len(imports) > 0 {
+		buf.WriteString("import (\n")
+		for imp := range imports {
+			fmt.Fprintf(&buf, "\t%q\n", imp)
 		}
-		// write snippets
-		for s := range pkg.snippets {
-			_, err = fo.WriteString(snippets[s].source)
-			if err != nil {
-				return err
+		buf.WriteString(")\n")
+	}
+	// write snippets
+	for _, s := range names {
+		buf.WriteString(snippets[s].source)
+	}
+	return buf.Bytes()
+}
+
+// saveSnippets saves the package snippets in a "snippets.go" file,
+// which gets added to the package. (Unless the file is already part
+// of the package after Recheck.)
+func (pkg *Package) saveSnippets() error {
+	if len(pkg.snippets) == 0 {
+		return nil
+	}
+	if _, ok := pkg.Ast.Files[pkg.snippetsFilename()]; ok {
+		return nil
+	}
+	return ioutil.WriteFile(pkg.snippetsFilename(), pkg.snippetsSource(),
+		0666)
+}
+
+// loadSnippets adds the snippets, which are declared in a previously
+// generated "snippets.go" file, so that they are kept if it is
+// generated again.
+func (pkg *Package) loadSnippets(f *ast.File) {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			if _, ok := snippets[fn.Name.Name]; ok {
+				pkg.snippets[fn.Name.Name] = struct{}{}
 			}
 		}
 	}
-	return nil
 }
 
 // Walk traverses an AST in depth-first order: It starts by calling
@@ -201,47 +247,85 @@ func (pkg *Package) Walk(v Visitor) error {
 	return nil
 }
 
-// New parses all packages from go files in the directory.
+// New parses all packages from go files in the directory, which match
+// the build constraints of the go environment (see NewContext).
 func New(dirname string) (Packages, error) {
+	return NewContext(dirname, &build.Default)
+}
+
+// NewContext parses all packages from the go files in the directory,
+// which match the build constraints (GOOS, GOARCH and tags) of a build
+// context. Files for other platforms or with "//go:build ignore" are
+// left out, so that each build variant is type checked separately. A
+// nil context parses all go files.
+func NewContext(dirname string, ctxt *build.Context) (Packages, error) {
 	var pkgs Packages
 	fset := token.NewFileSet()
-	pkgMap, err := parser.ParseDir(fset, dirname, nil, parser.ParseComments)
+	var filter func(os.FileInfo) bool
+	if ctxt != nil {
+		filter = func(info os.FileInfo) bool {
+			match, err := ctxt.MatchFile(dirname, info.Name())
+			return err == nil && match
+		}
+	}
+	pkgMap, err := parser.ParseDir(fset, dirname, filter,
+		parser.ParseComments)
 	if err != nil {
 		return pkgs, err
 	}
 	for name, pkgAst := range pkgMap {
-		var (
-			errs    []error
-			collect = func(err error) {
-				errs = append(errs, err)
-			}
-		)
-		info := &types.Info{
-			Types:      map[ast.Expr]types.TypeAndValue{},
-			Defs:       map[*ast.Ident]types.Object{},
-			Uses:       map[*ast.Ident]types.Object{},
-			Implicits:  map[ast.Node]types.Object{},
-			Selections: map[*ast.SelectorExpr]*types.Selection{},
-			Scopes:     map[ast.Node]*types.Scope{},
-			InitOrder:  []*types.Initializer{}}
-		pkgTypes, _ := (&types.Config{ // error should be handled by check
-			Error:  collect,
-			Import: nil,
-			DisableUnusedImportCheck: true,
-		}).Check(dirname, fset, files(pkgAst), info)
-		pkgs = append(pkgs, Package{
+		pkg := Package{
 			Name:     name,
 			Ast:      pkgAst,
-			Types:    pkgTypes,
 			Fset:     fset,
-			Info:     info,
-			Errors:   errs,
+			changed:  map[*ast.File]struct{}{},
+			sources:  map[string][]byte{},
 			snippets: Set{},
-		})
+		}
+		for filename, f := range pkgAst.Files {
+			src, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return pkgs, err
+			}
+			pkg.sources[filename] = src
+			if filepath.Base(filename) == "snippets.go" {
+				pkg.loadSnippets(f)
+			}
+		}
+		pkg.check(dirname)
+		pkgs = append(pkgs, pkg)
 	}
+	pkgs.SetImportPath(importPath(dirname))
 	return pkgs, nil
 }
 
+// check type checks the package. Type errors are collected in
+// pkg.Errors.
+func (pkg *Package) check(dirname string) {
+	var (
+		errs    []error
+		collect = func(err error) {
+			errs = append(errs, err)
+		}
+	)
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Implicits:  map[ast.Node]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Scopes:     map[ast.Node]*types.Scope{},
+		InitOrder:  []*types.Initializer{}}
+	pkgTypes, _ := (&types.Config{ // error should be handled by check
+		Error:  collect,
+		Import: pkg.importer(),
+		DisableUnusedImportCheck: true,
+	}).Check(dirname, pkg.Fset, files(pkg.Ast), info)
+	pkg.Types = pkgTypes
+	pkg.Info = info
+	pkg.Errors = errs
+}
+
 // Error returns the first error found.
 func (pkgs *Packages) Error() error {
 	for _, pkg := range *pkgs {
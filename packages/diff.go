@@ -0,0 +1,305 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of context lines of a unified diff.
+const diffContext = 3
+
+type (
+	// hunk replaces the lines a[A0:A1] by b[B0:B1].
+	hunk struct {
+		A0, A1, B0, B1 int
+	}
+	// mergeChunk is a part of a three-way merge. A chunk without
+	// conflict has the same ours and theirs lines.
+	mergeChunk struct {
+		base, ours, theirs []string
+		conflict           bool
+	}
+)
+
+// splitLines splits source code into lines, which keep their "\n".
+func splitLines(src string) []string {
+	lines := strings.SplitAfter(src, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the hunks which change a into b. It uses the
+// O(ND) algorithm of Myers after removing the common prefix and
+// suffix.
+func diffLines(a, b []string) []hunk {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre &&
+		a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	matches := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	// the end of both is a match, which closes the last hunk
+	matches = append(matches, [2]int{len(a) - suf - pre,
+		len(b) - suf - pre})
+	var hunks []hunk
+	x, y := 0, 0
+	for _, m := range matches {
+		if m[0] > x || m[1] > y {
+			hunks = append(hunks, hunk{pre + x, pre + m[0], pre + y,
+				pre + m[1]})
+		}
+		x, y = m[0]+1, m[1]+1
+	}
+	return hunks
+}
+
+// myers returns the matching lines (x, y) of a and b in order.
+func myers(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		// remember the furthest points of d-1 for k in [-d, d]
+		trace = append(trace, append([]int(nil),
+			v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	// backtrack from (n, m)
+	var matches [][2]int
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prev := trace[d]
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && prev[k-1+d] < prev[k+1+d]) {
+				prevK = k + 1
+			}
+			prevX = prev[prevK+d]
+			prevY = prevX - prevK
+		}
+		// the snake after the edit from (prevX, prevY)
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+// merge3 merges the changes from base to ours and from base to theirs.
+// Changes of both sides to the same lines (or insertions at the same
+// place) are a conflict, unless they are equal.
+func merge3(base, ours, theirs []string) []mergeChunk {
+	oh, th := diffLines(base, ours), diffLines(base, theirs)
+	var chunks []mergeChunk
+	stable := func(lo, hi int) {
+		if lo < hi {
+			lines := base[lo:hi]
+			chunks = append(chunks, mergeChunk{base: lines, ours: lines,
+				theirs: lines})
+		}
+	}
+	pos := 0
+	for len(oh) > 0 || len(th) > 0 {
+		// collect the overlapping hunks of both sides
+		var ogroup, tgroup []hunk
+		lo, hi := -1, -1
+		for {
+			var h hunk
+			ours := len(oh) > 0 && (len(th) == 0 || oh[0].A0 <= th[0].A0)
+			switch {
+			case ours:
+				h = oh[0]
+			case len(th) > 0:
+				h = th[0]
+			default:
+				h.A0 = -1
+			}
+			// insertions at the end of the group are ambiguous
+			if h.A0 < 0 || lo >= 0 && h.A0 > hi ||
+				lo >= 0 && h.A0 == hi && h.A0 < h.A1 && lo < hi {
+				break
+			}
+			if lo < 0 {
+				lo, hi = h.A0, h.A1
+			}
+			if h.A1 > hi {
+				hi = h.A1
+			}
+			if ours {
+				ogroup, oh = append(ogroup, h), oh[1:]
+			} else {
+				tgroup, th = append(tgroup, h), th[1:]
+			}
+		}
+		stable(pos, lo)
+		pos = hi
+		chunk := mergeChunk{
+			base:   base[lo:hi],
+			ours:   applyHunks(base, ours, ogroup, lo, hi),
+			theirs: applyHunks(base, theirs, tgroup, lo, hi),
+		}
+		chunk.conflict = len(ogroup) > 0 && len(tgroup) > 0 &&
+			strings.Join(chunk.ours, "") != strings.Join(chunk.theirs, "")
+		switch {
+		case len(ogroup) == 0:
+			chunk.ours = chunk.theirs
+		case len(tgroup) == 0, !chunk.conflict:
+			chunk.theirs = chunk.ours
+		}
+		chunks = append(chunks, chunk)
+	}
+	stable(pos, len(base))
+	return chunks
+}
+
+// applyHunks applies the hunks, which change base into b, to
+// base[lo:hi].
+func applyHunks(base, b []string, hunks []hunk, lo, hi int) []string {
+	lines := []string{}
+	pos := lo
+	for _, h := range hunks {
+		lines = append(lines, base[pos:h.A0]...)
+		lines = append(lines, b[h.B0:h.B1]...)
+		pos = h.A1
+	}
+	return append(lines, base[pos:hi]...)
+}
+
+// UnifiedDiff returns the unified diff of the source a and b, which
+// are named aName and bName in the header. It returns an empty
+// string if a and b are equal.
+func UnifiedDiff(aName, bName string, a, b []byte) string {
+	al, bl := splitLines(string(a)), splitLines(string(b))
+	hunks := diffLines(al, bl)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for i := 0; i < len(hunks); {
+		// join hunks with overlapping context
+		j := i + 1
+		for j < len(hunks) && hunks[j].A0-hunks[j-1].A1 <= 2*diffContext {
+			j++
+		}
+		first, last := hunks[i], hunks[j-1]
+		a0, a1 := first.A0-diffContext, last.A1+diffContext
+		if a0 < 0 {
+			a0 = 0
+		}
+		if a1 > len(al) {
+			a1 = len(al)
+		}
+		b0, b1 := first.B0-(first.A0-a0), last.B1+(a1-last.A1)
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", diffRange(a0, a1),
+			diffRange(b0, b1))
+		pos := a0
+		for _, h := range hunks[i:j] {
+			writeLines(&buf, " ", al[pos:h.A0])
+			writeLines(&buf, "-", al[h.A0:h.A1])
+			writeLines(&buf, "+", bl[h.B0:h.B1])
+			pos = h.A1
+		}
+		writeLines(&buf, " ", al[pos:a1])
+		i = j
+	}
+	return buf.String()
+}
+
+// diffRange formats the line range [lo, hi) of a hunk header.
+func diffRange(lo, hi int) string {
+	switch hi - lo {
+	case 0:
+		return fmt.Sprintf("%d,0", lo)
+	case 1:
+		return fmt.Sprintf("%d", lo+1)
+	}
+	return fmt.Sprintf("%d,%d", lo+1, hi-lo)
+}
+
+// writeLines writes the lines of a unified diff with a prefix.
+func writeLines(buf *bytes.Buffer, prefix string, lines []string) {
+	for _, line := range lines {
+		buf.WriteString(prefix + line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Merge merges the changes from base to ours and from base to theirs
+// into one source. Conflicts are marked with merge markers, which are
+// labeled with the names of ours and theirs:
+//
+//	<<<<<<< ours
+//	...
+//	=======
+//	...
+//	>>>>>>> theirs
+//
+// It returns the merged source and the number of conflicts.
+func Merge(base, ours, theirs []byte, oursName, theirsName string) (
+	[]byte, int) {
+	var buf bytes.Buffer
+	conflicts := 0
+	for _, chunk := range merge3(splitLines(string(base)),
+		splitLines(string(ours)), splitLines(string(theirs))) {
+		if !chunk.conflict {
+			buf.WriteString(strings.Join(chunk.ours, ""))
+			continue
+		}
+		conflicts++
+		buf.WriteString("<<<<<<< " + oursName + "\n")
+		writeMarked(&buf, chunk.ours)
+		buf.WriteString("=======\n")
+		writeMarked(&buf, chunk.theirs)
+		buf.WriteString(">>>>>>> " + theirsName + "\n")
+	}
+	return buf.Bytes(), conflicts
+}
+
+// writeMarked writes the lines of a conflict, so that the merge
+// marker after the last line starts on a new line.
+func writeMarked(buf *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		buf.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package packages
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines "1\n" to "n\n" with some lines replaced.
+func numbered(n int, replace map[int]string) string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		line, ok := replace[i]
+		if !ok {
+			line = fmt.Sprint(i)
+		}
+		lines = append(lines, line+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+func TestDiffApply(t *testing.T) {
+	a := numbered(20, nil)
+	b := numbered(20, map[int]string{10: "ten"})
+	tests := []struct {
+		name        string
+		a, b        string // the diff of a and b
+		target      string // the diff is applied to target
+		want        string
+		moved       string // the first message of a moved hunk
+		rejected    int
+		wantRejects string // a line of the rejects
+	}{
+		{name: "exact", a: a, b: b, target: a, want: b},
+		{name: "no changes", a: a, b: a, target: a, want: a},
+		{name: "two hunks", a: a,
+			b:      numbered(20, map[int]string{2: "two", 18: "eighteen"}),
+			target: a,
+			want:   numbered(20, map[int]string{2: "two", 18: "eighteen"})},
+		{name: "insert and delete", a: a,
+			b:      strings.Replace(a, "5\n", "5\nfive\n", 1)[2:],
+			target: a,
+			want:   strings.Replace(a, "5\n", "5\nfive\n", 1)[2:]},
+		{name: "no newline at end", a: "x\ny", b: "x\nz", target: "x\ny",
+			want: "x\nz"},
+		{name: "offset", a: a, b: b, target: "0\n-1\n" + a,
+			want:  "0\n-1\n" + b,
+			moved: "hunk #1 succeeded at 9 (offset 2 lines)"},
+		{name: "fuzz", a: a, b: b,
+			target: numbered(20, map[int]string{7: "seven"}),
+			want:   numbered(20, map[int]string{7: "seven", 10: "ten"}),
+			moved:  "hunk #1 succeeded at 7 with fuzz 1"},
+		{name: "offset and fuzz", a: a, b: b,
+			target: "0\n" + numbered(20, map[int]string{13: "thirteen"}),
+			want: "0\n" + numbered(20,
+				map[int]string{10: "ten", 13: "thirteen"}),
+			moved: "hunk #1 succeeded at 8 (offset 1 lines) with fuzz 1"},
+		{name: "reject", a: a, b: b,
+			target:   numbered(20, map[int]string{10: "10!"}),
+			want:     numbered(20, map[int]string{10: "10!"}),
+			rejected: 1, wantRejects: "@@ -7,7 +7,7 @@\n"},
+		{name: "reject too much fuzz", a: a, b: b,
+			target: numbered(20, map[int]string{7: "7!", 8: "8!",
+				9: "9!"}),
+			want: numbered(20, map[int]string{7: "7!", 8: "8!",
+				9: "9!"}),
+			rejected: 1, wantRejects: "-10\n+ten\n"},
+	}
+	for _, test := range tests {
+		diff := UnifiedDiff("a/x.go", "b/x.go", []byte(test.a),
+			[]byte(test.b))
+		fds, err := ParseDiff([]byte(diff))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if test.a == test.b {
+			if len(fds) != 0 {
+				t.Errorf("%s: got %d file diffs, want none", test.name,
+					len(fds))
+			}
+			continue
+		}
+		if len(fds) != 1 || fds[0].Old != "x.go" || fds[0].New != "x.go" {
+			t.Errorf("%s: got file diffs %+v", test.name, fds)
+			continue
+		}
+		got, r := fds[0].Apply([]byte(test.target))
+		if string(got) != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+		if r.Rejected != test.rejected {
+			t.Errorf("%s: got %d rejected hunks, want %d", test.name,
+				r.Rejected, test.rejected)
+		}
+		if r.Applied+r.Rejected != strings.Count(diff, "\n@@ ") {
+			t.Errorf("%s: %d applied and %d rejected hunks of\n%s",
+				test.name, r.Applied, r.Rejected, diff)
+		}
+		if !strings.Contains(r.Rejects, test.wantRejects) ||
+			(test.rejected == 0) != (r.Rejects == "") {
+			t.Errorf("%s: got rejects %q, want %q", test.name, r.Rejects,
+				test.wantRejects)
+		}
+		var moved string
+		if len(r.Moved) > 0 {
+			moved = r.Moved[0]
+		}
+		if moved != test.moved {
+			t.Errorf("%s: got moved %q, want %q", test.name, moved,
+				test.moved)
+		}
+	}
+}
+
+func TestParseDiffErrors(t *testing.T) {
+	tests := []struct {
+		name, diff string
+	}{
+		{"hunk without file", "@@ -1 +1 @@\n-a\n+b\n"},
+		{"invalid header", "--- a\n+++ b\n@@ -x +1 @@\n-a\n+b\n"},
+		{"incomplete hunk", "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-a\n+b\n"},
+		{"unexpected line", "--- a\n+++ b\n@@ -1 +1 @@\n-a\n*b\n"},
+	}
+	for _, test := range tests {
+		if _, err := ParseDiff([]byte(test.diff)); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name, ours, theirs, want string
+		conflicts                int
+	}{
+		{name: "no changes", ours: base, theirs: base, want: base},
+		{name: "ours", ours: "a\nB\nc\nd\ne\n", theirs: base,
+			want: "a\nB\nc\nd\ne\n"},
+		{name: "theirs", ours: base, theirs: "a\nb\nc\nd\nE\n",
+			want: "a\nb\nc\nd\nE\n"},
+		{name: "both", ours: "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n", want: "a\nB\nc\nd\nE\n"},
+		{name: "same change", ours: "a\nB\nc\nd\ne\n",
+			theirs: "a\nB\nc\nd\ne\n", want: "a\nB\nc\nd\ne\n"},
+		{name: "delete and change", ours: "a\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n", want: "a\nc\nd\nE\n"},
+		{name: "conflict", ours: "a\nB\nc\nd\ne\n",
+			theirs: "a\nX\nc\nd\ne\n",
+			want: "a\n<<<<<<< manual\nB\n=======\nX\n" +
+				">>>>>>> generated\nc\nd\ne\n", conflicts: 1},
+		{name: "two conflicts", ours: "A\nb\nc\nd\nE\n",
+			theirs: "X\nb\nc\nd\nY\n",
+			want: "<<<<<<< manual\nA\n=======\nX\n>>>>>>> generated\n" +
+				"b\nc\nd\n" +
+				"<<<<<<< manual\nE\n=======\nY\n>>>>>>> generated\n",
+			conflicts: 2},
+		{name: "insert at the same place", ours: "a\nb\n1\nc\nd\ne\n",
+			theirs: "a\nb\n2\nc\nd\ne\n",
+			want: "a\nb\n<<<<<<< manual\n1\n=======\n2\n" +
+				">>>>>>> generated\nc\nd\ne\n", conflicts: 1},
+		{name: "delete and change conflict", ours: "a\nc\nd\ne\n",
+			theirs: "a\nX\nc\nd\ne\n",
+			want: "a\n<<<<<<< manual\n=======\nX\n" +
+				">>>>>>> generated\nc\nd\ne\n", conflicts: 1},
+		{name: "no newline at end", ours: "a\nb\nc\nd\nE",
+			theirs: "a\nb\nc\nd\nX",
+			want: "a\nb\nc\nd\n<<<<<<< manual\nE\n=======\nX\n" +
+				">>>>>>> generated\n", conflicts: 1},
+	}
+	for _, test := range tests {
+		got, n := Merge([]byte(base), []byte(test.ours),
+			[]byte(test.theirs), "manual", "generated")
+		if string(got) != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+		if n != test.conflicts {
+			t.Errorf("%s: got %d conflicts, want %d", test.name, n,
+				test.conflicts)
+		}
+	}
+}
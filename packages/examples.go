@@ -0,0 +1,122 @@
+package packages
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// outputRe matches the comment with the expected output of an example
+// (the same as go test).
+var outputRe = regexp.MustCompile(`(?i)^[[:space:]]*(unordered )?output:`)
+
+// outputEdit replaces src[start:end] of a test file by text.
+type outputEdit struct {
+	start, end int
+	text       string
+}
+
+// RewriteExamples replaces the expected output ("// Output: ...") of
+// the examples in the test files of dirname by their actual output
+// (by example name), for example "5" by "5.5" after a conversion. Only
+// line comments are rewritten. It returns the number of examples
+// which were rewritten.
+func RewriteExamples(dirname string, outputs map[string]string) (int,
+	error) {
+	filenames, err := filepath.Glob(filepath.Join(dirname, "*_test.go"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return count, err
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+		if err != nil {
+			return count, err
+		}
+		var edits []outputEdit
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || fn.Body == nil {
+				continue
+			}
+			output, ok := outputs[fn.Name.Name]
+			if !ok {
+				continue
+			}
+			group := outputComment(f, fn.Body)
+			if group == nil {
+				continue
+			}
+			start := fset.Position(group.Pos()).Offset
+			end := fset.Position(group.End()).Offset
+			text := outputText(src, start, group, output)
+			if text != string(src[start:end]) {
+				edits = append(edits, outputEdit{start, end, text})
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		// the declarations are in order, so edit from the end
+		for i := len(edits) - 1; i >= 0; i-- {
+			e := edits[i]
+			src = append(src[:e.start:e.start],
+				append([]byte(e.text), src[e.end:]...)...)
+		}
+		if err := ioutil.WriteFile(filename, src, 0666); err != nil {
+			return count, err
+		}
+		count += len(edits)
+	}
+	return count, nil
+}
+
+// outputComment returns the output comment of an example, which is
+// its last comment group, or nil.
+func outputComment(f *ast.File, body *ast.BlockStmt) *ast.CommentGroup {
+	var last *ast.CommentGroup
+	for _, group := range f.Comments {
+		if group.Pos() > body.Lbrace && group.End() < body.Rbrace {
+			last = group
+		}
+	}
+	if last == nil || !strings.HasPrefix(last.List[0].Text, "//") ||
+		!outputRe.MatchString(last.Text()) {
+		return nil
+	}
+	return last
+}
+
+// outputText returns the line comments with the output of an example.
+// They keep the header ("Output:" or "Unordered output:") and the
+// indentation of the comment group, which starts at src[start:].
+func outputText(src []byte, start int, group *ast.CommentGroup,
+	output string) string {
+	lineStart := start
+	for lineStart > 0 && (src[lineStart-1] == ' ' ||
+		src[lineStart-1] == '\t') {
+		lineStart--
+	}
+	indent := string(src[lineStart:start])
+	first := strings.TrimSpace(strings.TrimPrefix(group.List[0].Text, "//"))
+	lines := []string{"// " + outputRe.FindString(first)}
+	if output != "" {
+		for _, line := range strings.Split(output, "\n") {
+			if line == "" {
+				lines = append(lines, "//")
+			} else {
+				lines = append(lines, "// "+line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n"+indent)
+}
@@ -19,7 +19,8 @@ type (
 		err    types.Error
 		fixErr error // non nil if error during fixing
 	}
-	// regexHandler used for convenience by pkg.re()
+	// regexHandler used for convenience by pkg.re(). Its errors end
+	// with the conflict (confl.err), see FixAll.
 	regexHandler func(pkg *Package, confl conflict, fromType,
 		toType string, matches []string) error
 )
@@ -31,63 +32,74 @@ var (
 	indexRe    = regexp.MustCompile(`index .+? must be integer`)
 	mismatchRe = regexp.MustCompile(`mismatched types (\w+) and (\w+)`)
 	modRe      = regexp.MustCompile(`operator [%] not defined`)
+	overRe     = regexp.MustCompile(`(-?\d+) (?:\(untyped int constant\) )?overflows (\w+)`)
+	overUseRe  = regexp.MustCompile(`cannot use (-?\d+) \(untyped int constant.*?\) as (\w+) value in .+\(overflows\)`)
 	returnRe   = regexp.MustCompile(`cannot return (.+?) \(variable of type (\w+)\) as value of type (\w+)`)
 	truncRe    = regexp.MustCompile(`truncated to int`)
+	truncUseRe = regexp.MustCompile(`\(untyped float constant.*?\) as (\w+) value in .+\(truncated\)`)
+	useRe      = regexp.MustCompile(`cannot use (.+?) \((?:variable|value) of type (\w+)\) as (\w+) value in`)
 )
 
-// Fix type conflicts in all packages
-func (pkgs *Packages) Fix(fromType, toType string, logConflicts bool) (int, error) {
+// Fix type conflicts in all packages. It returns the number of fixed
+// conflicts and the errors of the conflicts, which could not be fixed.
+func (pkgs *Packages) Fix(types TypeMap, logConflicts bool) (int, []error,
+	error) {
 	count := 0
-	for _, pkg := range *pkgs {
-		n, err := pkg.Fix(fromType, toType, logConflicts)
+	var unfixed []error
+	for i := range *pkgs {
+		n, errs, err := (*pkgs)[i].Fix(types, logConflicts)
 		if err != nil {
-			return count, err
+			return count, unfixed, err
 		}
 		count += n
+		unfixed = append(unfixed, errs...)
 	}
-	return count, nil
+	return count, unfixed, nil
 }
 
-// Fix type conflicts in a package
-func (pkg *Package) Fix(fromType, toType string, logConflicts bool) (int, error) {
+// Fix type conflicts in a package. The rule of the type map, which
+// applies to a conflict, is derived from the types in its message.
+// A conflict which can not be fixed does not stop the other fixes.
+// It returns the number of fixed conflicts and the errors of the
+// conflicts, which could not be fixed.
+func (pkg *Package) Fix(types TypeMap, logConflicts bool) (int, []error,
+	error) {
 	if len(pkg.Errors) == 0 {
-		return 0, nil
+		return 0, nil, nil
 	}
 	conflicts, err := pkg.conflicts(logConflicts)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	// fix type conflicts
-	fixedFiles := map[*ast.File]struct{}{}
+	count := 0
+	var unfixed []error
 	for _, confl := range conflicts {
 		// find the appropriate fix with regular expressions
 		switch {
-		case pkg.re(argRe, fixArg, confl, fromType, toType),
-			pkg.re(chanRe, fixChan, confl, fromType, toType),
-			pkg.re(indexRe, fixIndex, confl, fromType, toType),
-			pkg.re(mismatchRe, fixMismatch, confl, fromType, toType),
-			pkg.re(modRe, fixMod, confl, fromType, toType),
-			pkg.re(returnRe, fixReturn, confl, fromType, toType),
-			pkg.re(truncRe, fixTrunc, confl, fromType, toType):
+		case pkg.re(argRe, fixArg, &confl, types),
+			pkg.re(chanRe, fixChan, &confl, types),
+			pkg.re(indexRe, fixIndex, &confl, types),
+			pkg.re(mismatchRe, fixMismatch, &confl, types),
+			pkg.re(modRe, fixMod, &confl, types),
+			pkg.re(overRe, fixOverflow, &confl, types),
+			pkg.re(overUseRe, fixOverflow, &confl, types),
+			pkg.re(returnRe, fixUse, &confl, types),
+			pkg.re(truncRe, fixTrunc, &confl, types),
+			pkg.re(truncUseRe, fixTrunc, &confl, types),
+			pkg.re(useRe, fixUse, &confl, types):
 		default:
 			confl.fixErr = fmt.Errorf("fix unknown: %s", confl.err)
 		}
 		if confl.fixErr != nil {
-			// do not return immediately, first save fixes
-			err = confl.fixErr
-			break
+			unfixed = append(unfixed, confl.fixErr)
+			continue
 		}
-		fixedFiles[confl.file] = struct{}{}
-	}
-	// save fixes & snippets
-	for f := range fixedFiles {
-		SaveFile(pkg.Fset, f, Filename(pkg.Fset, f))
+		count++
+		pkg.changed[confl.file] = struct{}{}
 	}
-	pkg.saveSnippets()
-	if err != nil {
-		return 0, err
-	}
-	return len(pkg.Errors), nil
+	// fixes are only in memory until Recheck or Save
+	return count, unfixed, nil
 }
 
 // conflicts collects all type errors
@@ -130,10 +142,10 @@ func (pkg *Package) conflicts(logConflicts bool) ([]conflict, error) {
 		}
 		confl.path = path
 
-		fmt.Println("  +", err)
+		fmt.Fprintln(pkg.out(), "  +", err)
 		if logConflicts {
 			pkg.printPath(path)
-			fmt.Println()
+			fmt.Fprintln(pkg.out())
 		}
 
 		conflicts = append(conflicts, confl)
@@ -144,11 +156,17 @@ func (pkg *Package) conflicts(logConflicts bool) ([]conflict, error) {
 // re checks conflict error message with a regular expression. If it
 // matches, it invokes the corresponding fix* handler. If the fix
 // is succesful, return true otherwise false so it can be sequenced
-// in a switch statement.
+// in a switch statement. An error of the handler is stored in
+// confl.fixErr.
 func (pkg *Package) re(re *regexp.Regexp, handler regexHandler,
-	confl conflict, fromType, toType string) bool {
+	confl *conflict, types TypeMap) bool {
 	if matches := re.FindAllStringSubmatch(confl.err.Msg, 1); len(matches) > 0 {
-		if err := handler(pkg, confl, fromType, toType,
+		// type names in messages may refer to the alias
+		for i := 1; i < len(matches[0]); i++ {
+			matches[0][i] = pkg.realName(matches[0][i])
+		}
+		fromType, toType := types.match(matches[0][1:])
+		if err := handler(pkg, *confl, fromType, toType,
 			matches[0]); err != nil {
 			confl.fixErr = err
 		}
@@ -173,21 +191,22 @@ func fixArg(pkg *Package, confl conflict, fromType, toType string,
 		switch fun := n0.Fun.(type) {
 		case *ast.Ident:
 			if len(n0.Args) == 1 && fun.Name == fromType {
-				if pkg.TypeStringOf(n0.Args[0]) == toType {
+				if pkg.realName(pkg.TypeStringOf(n0.Args[0])) == toType {
 					args[ia] = n0.Args[0]
 				} else {
-					fun.Name = toType
+					fun.Name = pkg.typeName(toType)
 				}
 				return nil
 			}
 		}
 	}
+	if pkg.fixMath(confl, matches[1]) {
+		return nil
+	}
 	if !strings.HasPrefix(matches[2], "*") {
 		// pkg.printPath(confl.path)
-		args[ia] = &ast.CallExpr{
-			Fun:  &ast.Ident{Name: matches[2]},
-			Args: []ast.Expr{astutil.Unparen(args[ia])},
-		}
+		args[ia] = pkg.convertFrom(confl.file, args[ia], matches[1],
+			matches[2], toType)
 		return nil
 	}
 	return fmt.Errorf("fixArgument unknown: %s", confl.err)
@@ -198,7 +217,8 @@ func fixChan(pkg *Package, confl conflict, fromType, toType string,
 	matches []string) error {
 	switch p0 := confl.path[0].(type) {
 	case *ast.SendStmt:
-		p0.Value = convert(p0.Value, matches[2])
+		p0.Value = pkg.convertFrom(confl.file, p0.Value, matches[1],
+			matches[2], toType)
 	}
 	return nil
 }
@@ -241,18 +261,24 @@ func fixMismatch(pkg *Package, confl conflict, fromType, toType string,
 				return fmt.Errorf("fixMismatch for AssignStmt unknown: %s",
 					confl.err)
 			}
-			n.Rhs[0] = convert(n.Rhs[0], toType)
+			n.Rhs[0] = pkg.convertFrom(confl.file, n.Rhs[0], fromType,
+				toType, toType)
 			return nil
 		case *ast.BinaryExpr:
+			// nested binary expressions may start at the same position
+			if pkg.realName(pkg.TypeStringOf(n.X)) != matches[1] ||
+				pkg.realName(pkg.TypeStringOf(n.Y)) != matches[2] {
+				continue
+			}
 			to := toType
-			if n.Op == token.REM {
+			if n.Op == token.REM && kind[toType] == token.FLOAT {
 				to = "int"
 			}
 			if matches[1] != to {
-				n.X = convert(n.X, to)
+				n.X = pkg.convertFrom(confl.file, n.X, matches[1], to, toType)
 			}
 			if matches[2] != to {
-				n.Y = convert(n.Y, to)
+				n.Y = pkg.convertFrom(confl.file, n.Y, matches[2], to, toType)
 			}
 			return nil
 		}
@@ -317,23 +343,57 @@ func fixMod(pkg *Package, confl conflict, fromType, toType string,
 	return nil
 }
 
-// fixReturn fixes a wrong return type
-func fixReturn(pkg *Package, confl conflict, fromType, toType string,
+// fixOverflow reports negative constants, which overflow an unsigned
+// type (eg var u uint = -1). They are not converted, as their value
+// would change silently, so the source has to be changed or skipped.
+func fixOverflow(pkg *Package, confl conflict, fromType, toType string,
 	matches []string) error {
-	fmt.Printf("%#v", matches)
-	return fmt.Errorf("bla")
+	if !strings.HasPrefix(matches[1], "-") ||
+		!strings.HasPrefix(matches[2], "uint") {
+		return fmt.Errorf("fixOverflow expects negative constant for unsigned type: %s",
+			confl.err)
+	}
+	return fmt.Errorf("negative constant %s can not be converted to %s: %s",
+		matches[1], matches[2], confl.err)
+}
+
+// fixUse fixes an expression which is used (assigned, returned, ...)
+// as a value of another type, for example the int result of len()
+// assigned to an int64.
+func fixUse(pkg *Package, confl conflict, fromType, toType string,
+	matches []string) error {
+	if len(confl.path) < 2 {
+		return fmt.Errorf("fixUse expects expression: %s", confl.err)
+	}
+	e, ok := confl.path[0].(ast.Expr)
+	if !ok {
+		return fmt.Errorf("fixUse expects expression, got %#v: %s",
+			confl.path[0], confl.err)
+	}
+	if pkg.fixMath(confl, matches[2]) {
+		return nil
+	}
+	if !replaceExpr(confl.path[1], e, pkg.convertFrom(confl.file, e,
+		matches[2], matches[3], toType)) {
+		return fmt.Errorf("fixUse can not replace expression: %s",
+			confl.err)
+	}
+	return nil
 }
 
 // fixTrunc fixes truncate to int for float constants
 func fixTrunc(pkg *Package, confl conflict, fromType, toType string,
 	matches []string) error {
+	if isReverse(fromType, toType) {
+		return fixQuantize(pkg, confl, fromType, toType, matches)
+	}
 	p0 := confl.path[0]
 	switch p1 := confl.path[1].(type) {
 	case *ast.CallExpr:
 		i := indexExpr(p1.Args, p0)
 		e := p1.Args[i]
 		var name string
-		typ := pkg.TypeStringOf(e)
+		typ := pkg.realName(pkg.TypeStringOf(e))
 		if typ == "untyped float" {
 			typ = toType
 		}
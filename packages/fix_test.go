@@ -0,0 +1,30 @@
+package packages
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFixAll checks that a conflict which can not be fixed does not
+// stop the other fixes and is returned with the error of its fix.
+func TestFixAll(t *testing.T) {
+	types := TypeMap{"int": "uint"}
+	pkgs, cleanup := convertSource(t, `package p
+
+func Last() int { return -1 }
+
+func Len(s []int) int { return len(s) }
+`, types, func(*Packages) {})
+	defer cleanup()
+	count, remaining, err := pkgs.FixAll(types, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d fixed conflicts, want 1", count)
+	}
+	if len(remaining) != 1 || !strings.HasPrefix(remaining[0].Error(),
+		"negative constant -1 can not be converted to uint") {
+		t.Errorf("got remaining conflicts %q", remaining)
+	}
+}
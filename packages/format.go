@@ -28,6 +28,7 @@ var (
 		"int16":   'd',
 		"int32":   'd',
 		"int64":   'd',
+		"uint":    'd',
 		"uint8":   'd',
 		"uint16":  'd',
 		"uint32":  'd',
@@ -39,9 +40,9 @@ var (
 
 // Format fixes string format functions such as Printf, Errorf, ...
 // in all packages.
-func (pkgs *Packages) Format(fromType string, formatVar map[string]struct{}, formatFunc map[string]string, printf map[string]int) error {
+func (pkgs *Packages) Format(types TypeMap, formatVar map[string]struct{}, formatFunc map[string]string, printf map[string]int) error {
 	for _, pkg := range *pkgs {
-		if err := pkg.Format(fromType, formatVar, formatFunc, printf); err != nil {
+		if err := pkg.Format(types, formatVar, formatFunc, printf); err != nil {
 			return err
 		}
 	}
@@ -50,10 +51,10 @@ func (pkgs *Packages) Format(fromType string, formatVar map[string]struct{}, for
 
 // Format fixes string format functions such as Printf, Errorf, ...
 // in a package.
-func (pkg *Package) Format(fromType string,
+func (pkg *Package) Format(types TypeMap,
 	formatVar map[string]struct{}, formatFunc map[string]string,
 	printf map[string]int) error {
-	return pkg.Walk(newFormatter(pkg, fromType, formatVar, formatFunc, printf))
+	return pkg.Walk(newFormatter(pkg, types, formatVar, formatFunc, printf))
 }
 
 // formatter fixes format strings in the files of a package
@@ -61,7 +62,7 @@ func (pkg *Package) Format(fromType string,
 // of the Visitor interface.
 type formatter struct {
 	pkg         *Package
-	fromRune    uint8
+	fromRunes   map[uint8]struct{}
 	formatVars  map[string]struct{}
 	formatVar   bool
 	formatFuncs map[string]string
@@ -71,12 +72,18 @@ type formatter struct {
 }
 
 // newFormatter creates a new formatter.
-func newFormatter(pkg *Package, fromType string,
+func newFormatter(pkg *Package, types TypeMap,
 	formatVar map[string]struct{}, formatFunc map[string]string,
 	printf map[string]int) *formatter {
+	fromRunes := map[uint8]struct{}{}
+	for from := range types {
+		if r, ok := verbRune[from]; ok {
+			fromRunes[r] = struct{}{}
+		}
+	}
 	return &formatter{
 		pkg:         pkg,
-		fromRune:    verbRune[fromType],
+		fromRunes:   fromRunes,
 		formatVars:  formatVar,
 		formatFuncs: formatFunc,
 		printf:      printf,
@@ -181,7 +188,7 @@ func (f *formatter) checkPrintf(call *ast.CallExpr, name string,
 		verb := format[start:end]
 		arg := call.Args[firstArg+i]
 		formatRune := verb[len(verb)-1]
-		if formatRune != f.fromRune {
+		if _, ok := f.fromRunes[formatRune]; !ok {
 			args = append(args, arg)
 			continue
 		}
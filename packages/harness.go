@@ -0,0 +1,347 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NumberPattern matches decimal numbers, eg "5", "-5.00" or "5e+06".
+const NumberPattern = `[-+]?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?`
+
+var numberRe = regexp.MustCompile(NumberPattern)
+
+// Normalize rewrites all numbers in their shortest form, so that
+// equal values have the same text (eg "5", "5.0" and "5.00"). The
+// differential harness does the same with diffNormalize.
+func Normalize(out []byte) []byte {
+	return numberRe.ReplaceAllFunc(out, func(number []byte) []byte {
+		f, err := strconv.ParseFloat(string(number), 64)
+		if err != nil {
+			return number
+		}
+		return []byte(strconv.FormatFloat(f, 'g', -1, 64))
+	})
+}
+
+type (
+	// harnessTarget is an exported function, which is called with the
+	// same fuzzed arguments in the original and the converted
+	// package. A method is called on a value which is created by a
+	// constructor with an io.Writer, so that its output is compared
+	// as well. (For example svgo.New(w).Circle(x, y, r).)
+	harnessTarget struct {
+		name    string // "Circle"
+		recv    string // "SVG" or empty for a function
+		ctor    string // "New" for a method
+		params  []harnessParam
+		results bool
+	}
+	// harnessParam is a fuzzed parameter of a harness target.
+	harnessParam struct {
+		from, to string // types in the original and converted package
+	}
+	// harnessDecls holds the exported declarations of a package, which
+	// are needed for a harness.
+	harnessDecls struct {
+		name  string
+		funcs map[string]*ast.FuncDecl // by "Name" or "Recv.Name"
+		ctors map[string]string        // constructor by type name
+	}
+)
+
+// DiffHarness returns the source of a test file for the converted
+// package in toDir, which compares it with the original package in
+// fromDir (imported as fromPath). Every exported function or method
+// with only parameters of the converted types (a final variadic
+// parameter is left out) gets a fuzz test, which calls both versions
+// with the same integer (or string) arguments and reports if their
+// results, output or panics differ after normalizing their numbers
+// (see Normalize). It also returns the number of fuzz tests, which is
+// zero if there is nothing to compare.
+func DiffHarness(fromDir, toDir, fromPath string, types TypeMap) ([]byte,
+	int, error) {
+	from, err := readHarnessDecls(fromDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	to, err := readHarnessDecls(toDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from.name == "main" || to.name == "" {
+		return nil, 0, nil
+	}
+	var targets []harnessTarget
+	for _, key := range sortedFuncs(from.funcs) {
+		target, ok := newHarnessTarget(from, to, key, types)
+		if ok {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, 0, nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `// Code generated by gofloat from %s. DO NOT EDIT.
+
+// This file compares the converted package with the original one:
+//
+//	go test -fuzz FuzzDiff<Name>
+
+package %s
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	original %q
+)
+
+// diffNumber matches decimal numbers.
+var diffNumber = regexp.MustCompile(%q)
+
+// diffCall calls f and returns its output or panic as text, in which
+// all numbers are in their shortest form (eg "1" for "1.000000").
+func diffCall(f func() string) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprint("panic: ", r)
+		}
+		s = diffNumber.ReplaceAllStringFunc(s, diffNormalize)
+	}()
+	return f()
+}
+
+// diffNormalize returns a number in its shortest form.
+func diffNormalize(number string) string {
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return number
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+`, fromPath, to.name, fromPath, NumberPattern)
+	for _, target := range targets {
+		target.write(&buf)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, 0, fmt.Errorf("harness of %q: %s", toDir, err)
+	}
+	return src, len(targets), nil
+}
+
+// readHarnessDecls parses the package (without tests) in dirname.
+func readHarnessDecls(dirname string) (harnessDecls, error) {
+	d := harnessDecls{funcs: map[string]*ast.FuncDecl{},
+		ctors: map[string]string{}}
+	notTest := func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}
+	pkgMap, err := parser.ParseDir(token.NewFileSet(), dirname, notTest, 0)
+	if err != nil {
+		return d, err
+	}
+	for name, pkg := range pkgMap {
+		d.name = name
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || !fn.Name.IsExported() {
+					continue
+				}
+				key := fn.Name.Name
+				if fn.Recv != nil && len(fn.Recv.List) > 0 {
+					recv := recvName(fn.Recv.List[0].Type)
+					if recv == "" {
+						continue
+					}
+					key = recv + "." + key
+				} else if typ := ctorType(fn); typ != "" {
+					if ctor, ok := d.ctors[typ]; !ok || key < ctor {
+						d.ctors[typ] = key
+					}
+				}
+				d.funcs[key] = fn
+			}
+		}
+	}
+	return d, nil
+}
+
+// recvName returns the name of an exported receiver type (T or *T).
+func recvName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok && ident.IsExported() {
+		return ident.Name
+	}
+	return ""
+}
+
+// ctorType returns the type T of a constructor func(w io.Writer) *T.
+func ctorType(fn *ast.FuncDecl) string {
+	params, results := fn.Type.Params.List, fn.Type.Results
+	if len(params) != 1 || len(params[0].Names) > 1 ||
+		results == nil || len(results.List) != 1 ||
+		len(results.List[0].Names) > 1 {
+		return ""
+	}
+	sel, ok := params[0].Type.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Writer" {
+		return ""
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "io" {
+		return ""
+	}
+	star, ok := results.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return ""
+	}
+	return recvName(star.X)
+}
+
+// sortedFuncs returns the sorted keys of funcs.
+func sortedFuncs(funcs map[string]*ast.FuncDecl) []string {
+	keys := make([]string, 0, len(funcs))
+	for key := range funcs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newHarnessTarget returns the harness target of the function key,
+// if its parameters can be fuzzed and it has a constructor in case of
+// a method.
+func newHarnessTarget(from, to harnessDecls, key string,
+	types TypeMap) (harnessTarget, bool) {
+	var target harnessTarget
+	fromFn, toFn := from.funcs[key], to.funcs[key]
+	if toFn == nil {
+		return target, false
+	}
+	target.name = fromFn.Name.Name
+	if fromFn.Recv != nil && len(fromFn.Recv.List) > 0 {
+		target.recv = recvName(fromFn.Recv.List[0].Type)
+		target.ctor = from.ctors[target.recv]
+		if target.ctor == "" || to.ctors[target.recv] != target.ctor {
+			return target, false
+		}
+	} else if ctorType(fromFn) != "" {
+		return target, false
+	}
+	fromTypes, toTypes := paramTypes(fromFn), paramTypes(toFn)
+	if len(fromTypes) != len(toTypes) {
+		return target, false
+	}
+	if n := len(fromTypes); n > 0 {
+		if _, ok := fromTypes[n-1].(*ast.Ellipsis); ok {
+			fromTypes, toTypes = fromTypes[:n-1], toTypes[:n-1]
+		}
+	}
+	converted := false
+	for i, expr := range fromTypes {
+		fromIdent, ok := expr.(*ast.Ident)
+		if !ok {
+			return target, false
+		}
+		toIdent, ok := toTypes[i].(*ast.Ident)
+		if !ok {
+			return target, false
+		}
+		_, numeric := types[fromIdent.Name]
+		switch {
+		case numeric:
+			converted = true
+		case fromIdent.Name != "string" || toIdent.Name != "string":
+			return target, false
+		}
+		target.params = append(target.params,
+			harnessParam{from: fromIdent.Name, to: toIdent.Name})
+	}
+	target.results = fromFn.Type.Results != nil &&
+		len(fromFn.Type.Results.List) > 0
+	return target, converted
+}
+
+// paramTypes returns the type of every parameter of a function.
+func paramTypes(fn *ast.FuncDecl) []ast.Expr {
+	var types []ast.Expr
+	for _, field := range fn.Type.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// write writes the fuzz test of a target.
+func (target harnessTarget) write(buf *bytes.Buffer) {
+	name := target.name
+	if target.recv != "" {
+		name = target.recv + "." + target.name
+	}
+	var seeds, params, fromArgs, toArgs, formats []string
+	for i, p := range target.params {
+		arg := fmt.Sprintf("a%d", i)
+		seed := fmt.Sprintf("%s(%d)", p.from, i+1)
+		if p.from == "string" {
+			seed = fmt.Sprintf("%q", "")
+		}
+		seeds = append(seeds, seed)
+		params = append(params, arg+" "+p.from)
+		fromArgs = append(fromArgs, arg)
+		toArgs = append(toArgs, p.to+"("+arg+")")
+		formats = append(formats, "%v")
+	}
+	call := func(pkg string, args []string) string {
+		fun := pkg + target.name
+		if target.recv != "" {
+			fun = pkg + target.ctor + "(&buf)." + target.name
+		}
+		expr := fun + "(" + strings.Join(args, ", ") + ")"
+		if target.results {
+			return "return buf.String() + fmt.Sprint(" + expr + ")"
+		}
+		return expr + "\n\t\t\treturn buf.String()"
+	}
+	fmt.Fprintf(buf, `
+func FuzzDiff%s(f *testing.F) {
+	f.Add(%s)
+	f.Fuzz(func(t *testing.T, %s) {
+		want := diffCall(func() string {
+			var buf bytes.Buffer
+			%s
+		})
+		got := diffCall(func() string {
+			var buf bytes.Buffer
+			%s
+		})
+		if want != got {
+			t.Errorf("%s(%s):\noriginal:  %%q\nconverted: %%q", %s, want, got)
+		}
+	})
+}
+`, strings.Replace(name, ".", "", 1), strings.Join(seeds, ", "),
+		strings.Join(params, ", "), call("original.", fromArgs),
+		call("", toArgs), name, strings.Join(formats, ", "),
+		strings.Join(fromArgs, ", "))
+}
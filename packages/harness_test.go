@@ -0,0 +1,67 @@
+package packages
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"1 1.000000 1.5", "1 1 1.5"},
+		{"x=-5.00 y=+2", "x=-5 y=2"},
+		{"5e+06 2.5E-01", "5e+06 0.25"},
+		{"<circle r=\"10.000\"/>", "<circle r=\"10\"/>"},
+	}
+	for _, test := range tests {
+		if got := string(Normalize([]byte(test.in))); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+// TestDiffHarness checks that the harness compares outputs after
+// normalizing their numbers, as "1" and "1.000000" are equal.
+func TestDiffHarness(t *testing.T) {
+	dirname, err := ioutil.TempDir("", "gofloat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirname)
+	for name, src := range map[string]string{
+		"src": "package p\n\nfunc Half(x int) int { return x / 2 }\n",
+		"dst": "package p\n\nfunc Half(x float64) float64 " +
+			"{ return x / 2 }\n",
+	} {
+		if err := os.Mkdir(filepath.Join(dirname, name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dirname, name, "p.go"),
+			[]byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, n, err := DiffHarness(filepath.Join(dirname, "src"),
+		filepath.Join(dirname, "dst"), "example.com/p",
+		TypeMap{"int": "float64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d fuzz tests, want 1", n)
+	}
+	for _, want := range []string{
+		"regexp.MustCompile(" + strconv.Quote(NumberPattern) + ")",
+		"s = diffNumber.ReplaceAllStringFunc(s, diffNormalize)",
+		"func FuzzDiffHalf(f *testing.F) {",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("harness without %q:\n%s", want, src)
+		}
+	}
+}
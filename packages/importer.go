@@ -0,0 +1,68 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/types"
+)
+
+// importer returns the importer of the type checker. An external test
+// package (eg "foo_test") imports the package under test from memory,
+// so that it sees its converted declarations instead of the compiled
+// package on disk. All other packages are imported by DefaultImport.
+func (pkg *Package) importer() types.Importer {
+	return func(imports map[string]*types.Package, path string) (
+		*types.Package, error) {
+		if pkg.tested != nil && path == pkg.importPath {
+			imports[path] = pkg.tested
+			return pkg.tested, nil
+		}
+		return types.DefaultImport(imports, path)
+	}
+}
+
+// isExternalTest checks if a package name is an external test package.
+func isExternalTest(name string) bool {
+	return strings.HasSuffix(name, "_test")
+}
+
+// importPath returns the import path of a directory in the GOPATH or
+// an empty string.
+func importPath(dirname string) string {
+	if !strings.HasPrefix(dirname, goPathSrc+string(os.PathSeparator)) {
+		return ""
+	}
+	return filepath.ToSlash(Repo(dirname))
+}
+
+// SetImportPath sets the import path of the package under test, which
+// is imported by the external test package from memory. (By default
+// it is the import path of the directory in the GOPATH, but a
+// converted package is saved somewhere else first.) The external test
+// package is type checked again.
+func (pkgs *Packages) SetImportPath(path string) {
+	for i := range *pkgs {
+		(*pkgs)[i].importPath = path
+	}
+	pkgs.checkTests()
+}
+
+// checkTests type checks the external test packages again with the
+// current types of the package under test.
+func (pkgs *Packages) checkTests() {
+	var tested *types.Package
+	for _, pkg := range *pkgs {
+		if !isExternalTest(pkg.Name) {
+			tested = pkg.Types
+		}
+	}
+	for i := range *pkgs {
+		pkg := &(*pkgs)[i]
+		if isExternalTest(pkg.Name) {
+			pkg.tested = tested
+			pkg.check(pkg.Path())
+		}
+	}
+}
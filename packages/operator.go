@@ -0,0 +1,473 @@
+package packages
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/types"
+)
+
+// Operators describes how arithmetic on a non-primitive type (for
+// example *big.Float, *big.Int or a decimal type) is rewritten into
+// method and constructor calls. Every rule is a go expression in
+// which the identifiers X and Y are replaced by the operands and V by
+// a constant. For *big.Float this could be:
+//
+//	Binary:  {"+": "new(big.Float).Add(X, Y)",
+//	          "<": "X.Cmp(Y) < 0", ...}
+//	Unary:   {"-": "new(big.Float).Neg(X)"}
+//	Literal: "big.NewFloat(V)"
+//	Imports: ["math/big"]
+//
+// Assignment operators (a += b) and increments (a++) are rewritten
+// with the corresponding binary rule (a = a + b).
+type Operators struct {
+	Binary  map[string]string
+	Unary   map[string]string
+	Literal string
+	Imports []string
+}
+
+// assignOp maps an assignment operator to its binary operator.
+var assignOp = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+// SetOperators sets the operators of non-primitive target types (by
+// type) for all packages. Their imports are added by Convert.
+func (pkgs *Packages) SetOperators(ops map[string]Operators) {
+	for i := range *pkgs {
+		(*pkgs)[i].operators = ops
+	}
+}
+
+// addOperatorImports adds the imports of the operators of the target
+// types to the files which use them, so that the converted package
+// can be type checked before its operators are rewritten. An alias
+// declares the type in its own file (see saveAlias).
+func (pkg *Package) addOperatorImports(types TypeMap) {
+	for _, from := range types.From() {
+		to := types[from]
+		ops, ok := pkg.operators[to]
+		if !ok || pkg.typeName(to) != to {
+			continue
+		}
+		for _, f := range pkg.Ast.Files {
+			if !usesIdent(f, to) {
+				continue
+			}
+			for _, imp := range ops.Imports {
+				astutil.AddImport(pkg.Fset, f, imp)
+			}
+			pkg.changed[f] = struct{}{}
+		}
+	}
+}
+
+// usesIdent checks if a file contains an identifier with a name.
+// (Converted type names such as "*big.Float" are single identifiers.)
+func usesIdent(f *ast.File, name string) bool {
+	found := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// RewriteOperators rewrites operators on toType in all packages
+// into method calls. It returns the number of rewrites.
+func (pkgs *Packages) RewriteOperators(toType string,
+	ops Operators) (int, error) {
+	count := 0
+	for i := range *pkgs {
+		n, err := (*pkgs)[i].RewriteOperators(toType, ops)
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// RewriteOperators rewrites operators on toType in a package into
+// method calls. The package should be type checked after Convert, so
+// that the types of the operands are known. (The operators itself
+// are type errors, which are expected.)
+func (pkg *Package) RewriteOperators(toType string,
+	ops Operators) (int, error) {
+	count := 0
+	for _, f := range pkg.Ast.Files {
+		rw := &operatorRewriter{
+			pkg:       pkg,
+			toType:    toType,
+			ops:       ops,
+			rewritten: map[ast.Expr]struct{}{},
+			objects:   map[types.Object]struct{}{},
+		}
+		astutil.Apply(f, nil, rw.post)
+		if rw.err != nil {
+			return count, fmt.Errorf("%s: %s",
+				pkg.Fset.Position(rw.errPos), rw.err)
+		}
+		if rw.count > 0 {
+			for _, imp := range ops.Imports {
+				astutil.AddImport(pkg.Fset, f, imp)
+			}
+			pkg.changed[f] = struct{}{}
+		}
+		count += rw.count
+	}
+	return count, nil
+}
+
+// operatorRewriter rewrites the operators of a single file.
+type operatorRewriter struct {
+	pkg       *Package
+	toType    string
+	ops       Operators
+	rewritten map[ast.Expr]struct{}     // expressions of toType
+	objects   map[types.Object]struct{} // variables of toType
+	count     int
+	err       error
+	errPos    token.Pos
+}
+
+// setError keeps the first error and its position.
+func (rw *operatorRewriter) setError(pos token.Pos, format string,
+	a ...interface{}) {
+	if rw.err == nil {
+		rw.err = fmt.Errorf(format, a...)
+		rw.errPos = pos
+	}
+}
+
+// isTarget checks if an expression is of toType.
+func (rw *operatorRewriter) isTarget(e ast.Expr) bool {
+	if _, ok := rw.rewritten[astutil.Unparen(e)]; ok {
+		return true
+	}
+	if ident, ok := astutil.Unparen(e).(*ast.Ident); ok {
+		if _, ok := rw.objects[rw.pkg.Info.ObjectOf(ident)]; ok {
+			return true
+		}
+	}
+	return rw.isTargetType(rw.pkg.Info.TypeOf(astutil.Unparen(e)))
+}
+
+// isTargetType checks if a type is toType. Packages are qualified by
+// their name, as in source code (eg "*big.Float").
+func (rw *operatorRewriter) isTargetType(typ types.Type) bool {
+	if typ == nil {
+		return false
+	}
+	qualifier := func(p *types.Package) string { return p.Name() }
+	return types.TypeString(typ, qualifier) == rw.toType
+}
+
+// isConstant checks if an expression is an untyped constant.
+func (rw *operatorRewriter) isConstant(e ast.Expr) bool {
+	tv, ok := rw.pkg.Info.Types[e]
+	if !ok || tv.Value == nil {
+		return false
+	}
+	basic, ok := tv.Type.(*types.Basic)
+	return ok && basic.Info()&types.IsUntyped != 0
+}
+
+// isNil checks if an expression is the predeclared nil.
+func (rw *operatorRewriter) isNil(e ast.Expr) bool {
+	ident, ok := astutil.Unparen(e).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	_, ok = rw.pkg.Info.ObjectOf(ident).(*types.Nil)
+	return ok
+}
+
+// post is called by astutil.Apply after the children of a node are
+// rewritten.
+func (rw *operatorRewriter) post(c *astutil.Cursor) bool {
+	if rw.err != nil {
+		return false
+	}
+	switch n := c.Node().(type) {
+	case *ast.BinaryExpr:
+		if !rw.isTarget(n.X) && !rw.isTarget(n.Y) {
+			break
+		}
+		// p == nil compares the pointer (or interface) itself
+		if rw.isNil(n.X) || rw.isNil(n.Y) {
+			break
+		}
+		e := rw.binary(n.Op, n.X, n.Y, n.Pos())
+		if e == nil {
+			break
+		}
+		switch n.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR,
+			token.GEQ:
+		default:
+			rw.rewritten[e] = struct{}{}
+		}
+		c.Replace(e)
+	case *ast.UnaryExpr:
+		if n.Op == token.AND || !rw.isTarget(n.X) {
+			break
+		}
+		rule, ok := rw.ops.Unary[n.Op.String()]
+		if !ok {
+			rw.setError(n.Pos(), "no rule for unary operator %s on %s",
+				n.Op, rw.toType)
+			break
+		}
+		e := rw.expr(n.Pos(), rule, map[string]ast.Expr{"X": n.X})
+		if e != nil {
+			rw.rewritten[e] = struct{}{}
+			c.Replace(e)
+		}
+	case *ast.AssignStmt:
+		rw.assignStmt(n)
+	case *ast.IncDecStmt:
+		if !rw.isTarget(n.X) {
+			break
+		}
+		op := token.ADD
+		if n.Tok == token.DEC {
+			op = token.SUB
+		}
+		one := &ast.BasicLit{Kind: token.INT, Value: "1"}
+		e := rw.binary(op, n.X, rw.literal(one), n.Pos())
+		if e != nil {
+			c.Replace(&ast.AssignStmt{
+				Lhs: []ast.Expr{n.X},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{e},
+			})
+		}
+	case *ast.ValueSpec:
+		if n.Type == nil {
+			rw.define(n.Names, n.Values)
+			break
+		}
+		if !rw.isTargetType(rw.pkg.Info.TypeOf(n.Type)) {
+			break
+		}
+		for i, v := range n.Values {
+			if rw.isConstant(v) {
+				n.Values[i] = rw.literal(v)
+			}
+		}
+	case *ast.CallExpr:
+		rw.callExpr(n)
+	}
+	return rw.err == nil
+}
+
+// assignStmt rewrites assignment operators (a += b -> a = a + b) and
+// constants assigned to toType (a = 5 -> a = big.NewFloat(5)).
+func (rw *operatorRewriter) assignStmt(as *ast.AssignStmt) {
+	if op, ok := assignOp[as.Tok]; ok {
+		if !rw.isTarget(as.Lhs[0]) {
+			return
+		}
+		e := rw.binary(op, as.Lhs[0], as.Rhs[0], as.Pos())
+		if e != nil {
+			as.Tok = token.ASSIGN
+			as.Rhs[0] = e
+		}
+		return
+	}
+	if as.Tok == token.DEFINE {
+		idents := make([]*ast.Ident, len(as.Lhs))
+		for i, lh := range as.Lhs {
+			idents[i], _ = lh.(*ast.Ident)
+		}
+		rw.define(idents, as.Rhs)
+		return
+	}
+	if as.Tok != token.ASSIGN || len(as.Lhs) != len(as.Rhs) {
+		return
+	}
+	for i, rh := range as.Rhs {
+		if rw.isTarget(as.Lhs[i]) && rw.isConstant(rh) {
+			as.Rhs[i] = rw.literal(rh)
+		}
+	}
+}
+
+// define remembers variables which are declared with a rewritten
+// value, as the type checker could not infer their type.
+func (rw *operatorRewriter) define(idents []*ast.Ident, values []ast.Expr) {
+	if len(idents) != len(values) {
+		return
+	}
+	for i, ident := range idents {
+		if _, ok := rw.rewritten[values[i]]; !ok || ident == nil {
+			continue
+		}
+		if obj := rw.pkg.Info.Defs[ident]; obj != nil {
+			rw.objects[obj] = struct{}{}
+		}
+	}
+}
+
+// callExpr rewrites constant arguments passed to parameters of toType.
+func (rw *operatorRewriter) callExpr(call *ast.CallExpr) {
+	sig, ok := rw.pkg.Info.TypeOf(call.Fun).(*types.Signature)
+	if !ok {
+		return
+	}
+	params := sig.Params()
+	for i, arg := range call.Args {
+		j := i
+		if j >= params.Len() {
+			if !sig.Variadic() {
+				return
+			}
+			j = params.Len() - 1
+		}
+		typ := params.At(j).Type()
+		if sig.Variadic() && j == params.Len()-1 {
+			if slice, ok := typ.(*types.Slice); ok {
+				typ = slice.Elem()
+			}
+		}
+		if rw.isTargetType(typ) && rw.isConstant(arg) {
+			call.Args[i] = rw.literal(arg)
+		}
+	}
+}
+
+// binary rewrites a binary operation on toType. A constant operand
+// is converted to toType first.
+func (rw *operatorRewriter) binary(op token.Token, x, y ast.Expr,
+	pos token.Pos) ast.Expr {
+	rule, ok := rw.ops.Binary[op.String()]
+	if !ok {
+		rw.setError(pos, "no rule for operator %s on %s", op, rw.toType)
+		return nil
+	}
+	if !rw.isTarget(x) && rw.isConstant(x) {
+		x = rw.literal(x)
+	}
+	if !rw.isTarget(y) && rw.isConstant(y) && op != token.SHL &&
+		op != token.SHR {
+		y = rw.literal(y)
+	}
+	return rw.expr(pos, rule, map[string]ast.Expr{"X": x, "Y": y})
+}
+
+// literal converts a constant into toType with the Literal rule.
+func (rw *operatorRewriter) literal(v ast.Expr) ast.Expr {
+	if rw.ops.Literal == "" {
+		return v
+	}
+	e := rw.expr(v.Pos(), rw.ops.Literal, map[string]ast.Expr{"V": v})
+	if e == nil {
+		return v
+	}
+	rw.rewritten[e] = struct{}{}
+	return e
+}
+
+// expr parses a rule and substitutes its placeholders.
+func (rw *operatorRewriter) expr(pos token.Pos, rule string,
+	args map[string]ast.Expr) ast.Expr {
+	for name, arg := range args {
+		// rewritten operands are calls, which need no parentheses
+		if _, ok := rw.rewritten[astutil.Unparen(arg)]; ok {
+			args[name] = astutil.Unparen(arg)
+		}
+	}
+	e, err := ruleExpr(pos, rule, args)
+	if err != nil {
+		rw.setError(pos, "%s", err)
+		return nil
+	}
+	rw.count++
+	return e
+}
+
+// ruleExpr parses a rule and substitutes its placeholders. The nodes
+// of the rule get the position pos of the rewritten expression, as
+// the printer would move the following comments into them otherwise.
+func ruleExpr(pos token.Pos, rule string, args map[string]ast.Expr) (
+	ast.Expr, error) {
+	e, err := parser.ParseExpr(rule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %q: %s", rule, err)
+	}
+	setPos(e, pos)
+	return astutil.Apply(e, func(c *astutil.Cursor) bool {
+		ident, ok := c.Node().(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if sel, ok := c.Parent().(*ast.SelectorExpr); ok && sel.Sel == ident {
+			return true
+		}
+		if arg, ok := args[ident.Name]; ok {
+			c.Replace(arg)
+		}
+		return true
+	}, nil).(ast.Expr), nil
+}
+
+// literalRule converts e into toType with the Literal rule of its
+// operators during Convert, eg "0" -> "big.NewFloat(0)". It returns
+// nil if toType has no Literal rule.
+func (c *convertor) literalRule(toType string, e ast.Expr) ast.Expr {
+	rule := c.pkg.operators[toType].Literal
+	if rule == "" {
+		return nil
+	}
+	lit, err := ruleExpr(e.Pos(), rule, map[string]ast.Expr{"V": e})
+	if err != nil {
+		c.err = err
+		return nil
+	}
+	return lit
+}
+
+// clearPos resets the positions of a parsed node, which belong to
+// another file set.
+func clearPos(node ast.Node) {
+	setPos(node, token.NoPos)
+}
+
+// setPos sets all positions of a node to pos. Positions of absent
+// tokens (eg the Ellipsis of a call) are only cleared.
+func setPos(node ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if f.Type() == posType && f.CanSet() &&
+				(pos == token.NoPos || f.Int() != int64(token.NoPos)) {
+				f.SetInt(int64(pos))
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,278 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bigFloat are the operator rules of the *big.Float example.
+var bigFloat = Operators{
+	Binary: map[string]string{
+		"+":  "new(big.Float).Add(X, Y)",
+		"-":  "new(big.Float).Sub(X, Y)",
+		"*":  "new(big.Float).Mul(X, Y)",
+		"<":  "X.Cmp(Y) < 0",
+		"==": "X.Cmp(Y) == 0",
+	},
+	Unary:   map[string]string{"-": "new(big.Float).Neg(X)"},
+	Literal: "big.NewFloat(V)",
+	Imports: []string{"math/big"},
+}
+
+// testPackage parses and type checks a package with a single file in
+// a temporary folder, which is removed by the returned function.
+func testPackage(t *testing.T, src string) (*Package, func()) {
+	dirname, err := ioutil.TempDir("", "gofloat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dirname, "p.go"),
+		[]byte(src), 0666); err != nil {
+		os.RemoveAll(dirname)
+		t.Fatal(err)
+	}
+	pkgs, err := NewContext(dirname, nil)
+	if err != nil || len(pkgs) != 1 {
+		os.RemoveAll(dirname)
+		t.Fatalf("NewContext: %v (%d packages)", err, len(pkgs))
+	}
+	pkgs.SetOutput(ioutil.Discard)
+	return &pkgs[0], func() { os.RemoveAll(dirname) }
+}
+
+// firstStmts formats the first statement of every function by name.
+func firstStmts(t *testing.T, pkg *Package) map[string]string {
+	stmts := map[string]string{}
+	for _, f := range pkg.Ast.Files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || len(fn.Body.List) == 0 {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := format.Node(&buf, pkg.Fset,
+				fn.Body.List[0]); err != nil {
+				t.Fatal(err)
+			}
+			stmts[fn.Name.Name] = buf.String()
+		}
+	}
+	return stmts
+}
+
+func TestRewriteOperators(t *testing.T) {
+	tests := []struct {
+		stmt, want string
+	}{
+		// binary
+		{"_ = a + b", "_ = new(big.Float).Add(a, b)"},
+		{"_ = a + b*a", "_ = new(big.Float).Add(a, new(big.Float).Mul(b, a))"},
+		{"_ = a < b", "_ = a.Cmp(b) < 0"},
+		{"_ = (a - b) == a", "_ = new(big.Float).Sub(a, b).Cmp(a) == 0"},
+		// literal
+		{"_ = a + 1", "_ = new(big.Float).Add(a, big.NewFloat(1))"},
+		{"_ = 2.5 < a", "_ = big.NewFloat(2.5).Cmp(a) < 0"},
+		{"a = 3", "a = big.NewFloat(3)"},
+		{"var c *big.Float = 2", "var c *big.Float = big.NewFloat(2)"},
+		{"g(1)", "g(big.NewFloat(1))"},
+		// unary
+		{"_ = -a", "_ = new(big.Float).Neg(a)"},
+		{"_ = -(a + b)", "_ = new(big.Float).Neg(new(big.Float).Add(a, b))"},
+		{"_ = &a", "_ = &a"},
+		// assignment operators and increments
+		{"a += b", "a = new(big.Float).Add(a, b)"},
+		{"a -= 1", "a = new(big.Float).Sub(a, big.NewFloat(1))"},
+		{"a++", "a = new(big.Float).Add(a, big.NewFloat(1))"},
+		// comparisons with nil are kept
+		{"_ = a == nil", "_ = a == nil"},
+		{"_ = nil == a", "_ = nil == a"},
+		{"_ = i == nil", "_ = i == nil"},
+	}
+	// every statement is in its own function f0, f1, ...
+	var src bytes.Buffer
+	src.WriteString(`package p
+
+import "math/big"
+
+var i interface{}
+
+func g(x *big.Float) {}
+`)
+	for j, test := range tests {
+		fmt.Fprintf(&src, "\nfunc f%d(a, b *big.Float) {\n\t%s\n}\n", j,
+			test.stmt)
+	}
+	pkg, cleanup := testPackage(t, src.String())
+	defer cleanup()
+	if _, err := pkg.RewriteOperators("*big.Float", bigFloat); err != nil {
+		t.Fatal(err)
+	}
+	stmts := firstStmts(t, pkg)
+	for j, test := range tests {
+		if got := stmts[fmt.Sprintf("f%d", j)]; got != test.want {
+			t.Errorf("%s: got %q, want %q", test.stmt, got, test.want)
+		}
+	}
+}
+
+func TestRewriteOperatorsNoRule(t *testing.T) {
+	pkg, cleanup := testPackage(t, `package p
+
+import "math/big"
+
+func f(a, b *big.Float) {
+	_ = a / b
+}
+`)
+	defer cleanup()
+	if _, err := pkg.RewriteOperators("*big.Float", bigFloat); err == nil {
+		t.Error("expected an error for an operator without rule")
+	}
+}
+
+// convertSource converts a package with a single file p.go, as
+// gofloat does, and returns the converted packages in memory before
+// their type conflicts are fixed. setup configures the packages of
+// both phases. The returned function removes the temporary folders.
+func convertSource(t *testing.T, src string, types TypeMap,
+	setup func(*Packages)) (Packages, func()) {
+	fromDir, err := ioutil.TempDir("", "gofloat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toDir, err := ioutil.TempDir("", "gofloat")
+	if err != nil {
+		os.RemoveAll(fromDir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		os.RemoveAll(fromDir)
+		os.RemoveAll(toDir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fromDir, "p.go"),
+		[]byte(src), 0666); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	pkgs, err := NewContext(fromDir, nil)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	pkgs.SetOutput(ioutil.Discard)
+	setup(&pkgs)
+	if err := pkgs.Convert(types, toDir, Skip{},
+		map[string]string{}); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := pkgs.Save(toDir); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	pkgs, err = NewContext(toDir, nil)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	pkgs.SetOutput(ioutil.Discard)
+	setup(&pkgs)
+	return pkgs, cleanup
+}
+
+// fixSource fixes the type conflicts of converted packages and
+// formats their single file.
+func fixSource(t *testing.T, pkgs Packages, types TypeMap) string {
+	_, remaining, err := pkgs.FixAll(types, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range remaining {
+		t.Errorf("unfixed type conflict: %s", err)
+	}
+	var buf bytes.Buffer
+	for _, f := range pkgs[0].Ast.Files {
+		if err := format.Node(&buf, pkgs[0].Fset, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+// TestConvertOperators converts int to *big.Float, as gofloat does:
+// Convert adds the imports of the rules, before the first type check.
+func TestConvertOperators(t *testing.T) {
+	types := TypeMap{"int": "*big.Float"}
+	pkgs, cleanup := convertSource(t, `package p
+
+// Sum adds the values.
+func Sum(xs []int) int {
+	s := 0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+// Mid is the middle of a and b.
+func Mid(a, b int) int {
+	if a < b {
+		return a + (b-a)*2
+	}
+	return -a
+}
+
+// Conv converts.
+func Conv(i int32) int {
+	return int(i) + 1
+}
+`, types, func(pkgs *Packages) {
+		pkgs.SetOperators(map[string]Operators{"*big.Float": bigFloat})
+	})
+	defer cleanup()
+	n, err := pkgs.RewriteOperators("*big.Float", bigFloat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("no operators rewritten")
+	}
+	if err := pkgs.Recheck(); err != nil {
+		t.Fatal(err)
+	}
+	want := `package p
+
+import "math/big"
+
+// Sum adds the values.
+func Sum(xs []*big.Float) *big.Float {
+	s := big.NewFloat(0)
+	for _, x := range xs {
+		s = new(big.Float).Add(s, x)
+	}
+	return s
+}
+
+// Mid is the middle of a and b.
+func Mid(a, b *big.Float) *big.Float {
+	if a.Cmp(b) < 0 {
+		return new(big.Float).Add(a, new(big.Float).Mul(new(big.Float).Sub(b, a), big.NewFloat(2)))
+	}
+	return new(big.Float).Neg(a)
+}
+
+// Conv converts.
+func Conv(i int32) *big.Float {
+	return new(big.Float).Add(big.NewFloat(float64(i)), big.NewFloat(1))
+}
+`
+	if got := fixSource(t, pkgs, types); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,529 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/types"
+)
+
+// Op is a patch operation, which is applied on the syntax tree of a
+// converted package with type information. Unlike text patches it does
+// not depend on the layout of the source. The operations are:
+//
+//	AddField:  {"Op": "AddField", "Name": "SVG", "Code": "FloatVerb string",
+//	            "Value": "\"%.2f\""}
+//	AddMethod: {"Op": "AddMethod", "Code": "func (svg *SVG) f(...) ...",
+//	            "Imports": ["fmt"]}
+//	ToMethod:  {"Op": "ToMethod", "Name": "translate", "Recv": "svg *SVG"}
+//	Rename:    {"Op": "Rename", "Name": "SVG.Writer", "To": "W"}
+//	Insert:    {"Op": "Insert", "Name": "New", "Code": "...", "At": "end"}
+//
+// AddField initializes a single new field with Value in all composite
+// literals of the struct. ToMethod gives a function a receiver and rewrites all its uses into
+// method calls on the receiver (or on the receiver of the enclosing
+// method of the same type). Rename renames a package level identifier
+// or a field or method ("Type.Name") everywhere it is used. Insert
+// inserts statements at the "start" (default) or the "end" of a
+// function body (before a final return statement), without their
+// comments. Operations which were already applied are skipped, so
+// that they can be applied to every build variant.
+type Op struct {
+	Op      string   // AddField, AddMethod, ToMethod, Rename or Insert
+	Name    string   // of the type, function or identifier
+	To      string   // new name of Rename
+	Recv    string   // receiver of ToMethod: "svg *SVG"
+	Code    string   // field, declarations or statements
+	Value   string   // of the field of AddField
+	At      string   // of Insert: "start" or "end"
+	Imports []string // used by Code
+}
+
+// String implements the fmt.Stringer interface.
+func (op Op) String() string {
+	if op.Name == "" {
+		return op.Op
+	}
+	return op.Op + " " + op.Name
+}
+
+// ApplyOps applies patch operations to the package of a file (by base
+// name). It returns the number of applied operations. A file which is
+// not in the packages (eg in another build variant) is ignored.
+func (pkgs *Packages) ApplyOps(base string, ops []Op) (int, error) {
+	for i := range *pkgs {
+		pkg := &(*pkgs)[i]
+		for filename, f := range pkg.Ast.Files {
+			if filepath.Base(filename) == base {
+				count, err := pkg.ApplyOps(f, ops)
+				if err != nil {
+					return count, fmt.Errorf("%s: %s", base, err)
+				}
+				if count > 0 && !isExternalTest(pkg.Name) {
+					pkgs.checkTests()
+				}
+				return count, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// ApplyOps applies patch operations to a package. Code is added to
+// file f. The package is type checked again after every operation, so
+// that the next one sees its result. An operation fails if the package
+// has type errors afterwards.
+func (pkg *Package) ApplyOps(f *ast.File, ops []Op) (int, error) {
+	filename := Filename(pkg.Fset, f)
+	count := 0
+	for _, op := range ops {
+		var (
+			applied bool
+			err     error
+		)
+		switch op.Op {
+		case "AddField":
+			applied, err = pkg.addField(op)
+		case "AddMethod":
+			applied, err = pkg.addMethod(f, op)
+		case "ToMethod":
+			applied, err = pkg.toMethod(op)
+		case "Rename":
+			applied, err = pkg.rename(op)
+		case "Insert":
+			applied, err = pkg.insert(op)
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			return count, fmt.Errorf("%s: %s", op, err)
+		}
+		if !applied {
+			continue
+		}
+		f = pkg.Ast.Files[filename] // addMethod reparses the file
+		if len(op.Imports) > 0 {
+			for _, imp := range op.Imports {
+				astutil.AddImport(pkg.Fset, f, imp)
+			}
+			pkg.changed[f] = struct{}{}
+		}
+		if err := pkg.Recheck(); err != nil {
+			return count, fmt.Errorf("%s: %s", op, err)
+		}
+		if len(pkg.Errors) > 0 {
+			return count, fmt.Errorf("%s: %s", op, pkg.Errors[0])
+		}
+		f = pkg.Ast.Files[filename]
+		count++
+	}
+	return count, nil
+}
+
+// lookupDecl returns the declaration of a package level function and
+// its file. Methods are found by "Type.Method".
+func (pkg *Package) lookupDecl(name string) (*ast.FuncDecl, *ast.File) {
+	recv, name := "", name
+	if i := strings.Index(name, "."); i >= 0 {
+		recv, name = name[:i], name[i+1:]
+	}
+	for _, f := range pkg.Ast.Files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Name.Name == name && recvType(fn) == recv {
+				return fn, f
+			}
+		}
+	}
+	return nil, nil
+}
+
+// recvType returns the name of the receiver type of a method (without
+// "*") or an empty string for a function.
+func recvType(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	typ := fn.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// lookupStruct returns the struct type of a type declaration.
+func (pkg *Package) lookupStruct(name string) (*ast.StructType, *ast.File) {
+	for _, f := range pkg.Ast.Files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != name {
+					continue
+				}
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					return st, f
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// addField adds the fields of op.Code to a struct type. Fields which
+// already exist are skipped. Composite literals of the type without
+// keys (eg &SVG{w}) get keys, so that they stay valid.
+func (pkg *Package) addField(op Op) (bool, error) {
+	st, f := pkg.lookupStruct(op.Name)
+	if st == nil {
+		return false, fmt.Errorf("struct type %q not found", op.Name)
+	}
+	e, err := parser.ParseExpr("struct{\n" + op.Code + "\n}")
+	if err != nil {
+		return false, err
+	}
+	existing := Set{}
+	for _, field := range st.Fields.List {
+		for _, name := range field.Names {
+			existing[name.Name] = struct{}{}
+		}
+	}
+	fields := e.(*ast.StructType).Fields.List
+	if op.Value != "" && (len(fields) != 1 || len(fields[0].Names) != 1) {
+		return false, fmt.Errorf("a value needs a single field")
+	}
+	applied := false
+	for _, field := range fields {
+		if len(field.Names) > 0 {
+			if _, ok := existing[field.Names[0].Name]; ok {
+				continue
+			}
+		}
+		clearPos(field)
+		st.Fields.List = append(st.Fields.List, field)
+		applied = true
+	}
+	if !applied {
+		return false, nil
+	}
+	pkg.changed[f] = struct{}{}
+	field := ""
+	if op.Value != "" {
+		field = fields[0].Names[0].Name
+	}
+	return true, pkg.keyLiterals(op.Name, field, op.Value)
+}
+
+// keyLiterals adds the field names as keys to the composite literals
+// of a struct type without keys. If value is not empty, it initializes
+// the (new) field in all of them.
+func (pkg *Package) keyLiterals(name, field, value string) error {
+	tn, ok := pkg.Types.Scope().Lookup(name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	st, ok := tn.Type().Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	var err error
+	for _, f := range pkg.Ast.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.CompositeLit)
+			if !ok || err != nil || len(lit.Elts) > st.NumFields() ||
+				pkg.Info.TypeOf(lit) != tn.Type() {
+				return true
+			}
+			if len(lit.Elts) > 0 {
+				if _, ok := lit.Elts[0].(*ast.KeyValueExpr); !ok {
+					for i, elt := range lit.Elts {
+						lit.Elts[i] = &ast.KeyValueExpr{
+							Key:   ast.NewIdent(st.Field(i).Name()),
+							Value: elt,
+						}
+					}
+					pkg.changed[f] = struct{}{}
+				}
+			}
+			if value == "" {
+				return true
+			}
+			var e ast.Expr
+			if e, err = parser.ParseExpr(value); err != nil {
+				return false
+			}
+			// before the closing brace, so that comments stay outside
+			kv := &ast.KeyValueExpr{Key: ast.NewIdent(field), Value: e}
+			setPos(kv, lit.Rbrace)
+			lit.Elts = append(lit.Elts, kv)
+			pkg.changed[f] = struct{}{}
+			return true
+		})
+	}
+	return err
+}
+
+// addMethod appends the declarations of op.Code to file f. The source
+// is appended as text, so that comments are kept. Declarations which
+// already exist are skipped.
+func (pkg *Package) addMethod(f *ast.File, op Op) (bool, error) {
+	code, err := parser.ParseFile(token.NewFileSet(), "",
+		"package "+f.Name.Name+"\n\n"+op.Code, parser.ParseComments)
+	if err != nil {
+		return false, err
+	}
+	for _, decl := range code.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			return false, fmt.Errorf("%q is not a function declaration",
+				op.Code)
+		}
+		name := fn.Name.Name
+		if recv := recvType(fn); recv != "" {
+			name = recv + "." + name
+		}
+		if existing, _ := pkg.lookupDecl(name); existing != nil {
+			return false, nil
+		}
+	}
+	filename := Filename(pkg.Fset, f)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, pkg.Fset, f); err != nil {
+		return false, err
+	}
+	buf.WriteString("\n" + strings.TrimSpace(op.Code) + "\n")
+	delete(pkg.changed, f)
+	if err := pkg.reparse(filename, buf.Bytes()); err != nil {
+		return false, err
+	}
+	pkg.changed[pkg.Ast.Files[filename]] = struct{}{}
+	return true, nil
+}
+
+// toMethod gives a function the receiver op.Recv and rewrites its uses
+// into uses of the method.
+func (pkg *Package) toMethod(op Op) (bool, error) {
+	e, err := parser.ParseExpr("func(" + op.Recv + ")")
+	if err != nil {
+		return false, err
+	}
+	params := e.(*ast.FuncType).Params.List
+	if len(params) != 1 || len(params[0].Names) != 1 {
+		return false, fmt.Errorf("invalid receiver %q", op.Recv)
+	}
+	recv := params[0]
+	clearPos(recv)
+	fn, f := pkg.lookupDecl(op.Name)
+	if fn == nil {
+		method := &ast.FuncDecl{Recv: &ast.FieldList{
+			List: []*ast.Field{recv}}}
+		if m, _ := pkg.lookupDecl(recvType(method) + "." +
+			op.Name); m != nil {
+			return false, nil // already a method
+		}
+		return false, fmt.Errorf("function %q not found", op.Name)
+	}
+	obj := pkg.Info.Defs[fn.Name]
+	typ := types.ExprString(recv.Type)
+	// the function can only be used in methods of the same receiver
+	for _, file := range pkg.Ast.Files {
+		for _, decl := range file.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && (d == fn ||
+				methodRecv(d, typ) != "") {
+				continue
+			}
+			if pos := pkg.findUse(decl, obj); pos.IsValid() {
+				return false, fmt.Errorf("%s: %s is used outside a "+
+					"method of %s", pkg.Fset.Position(pos), op.Name, typ)
+			}
+		}
+	}
+	fn.Recv = &ast.FieldList{List: []*ast.Field{recv}}
+	pkg.changed[f] = struct{}{}
+	for _, file := range pkg.Ast.Files {
+		for _, decl := range file.Decls {
+			d, ok := decl.(*ast.FuncDecl)
+			if !ok || d.Body == nil {
+				continue
+			}
+			name := methodRecv(d, typ)
+			if name == "" {
+				continue
+			}
+			if pkg.methodUses(d, obj, name) {
+				pkg.changed[file] = struct{}{}
+			}
+		}
+	}
+	return true, nil
+}
+
+// methodRecv returns the receiver name of a method with the receiver
+// type typ (eg "*SVG") or an empty string.
+func methodRecv(d *ast.FuncDecl, typ string) string {
+	if d.Recv == nil || len(d.Recv.List) != 1 ||
+		len(d.Recv.List[0].Names) != 1 ||
+		types.ExprString(d.Recv.List[0].Type) != typ {
+		return ""
+	}
+	name := d.Recv.List[0].Names[0].Name
+	if name == "_" {
+		return ""
+	}
+	return name
+}
+
+// findUse returns the position of the first use of an object in a
+// declaration or token.NoPos.
+func (pkg *Package) findUse(decl ast.Decl, obj types.Object) token.Pos {
+	pos := token.NoPos
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && !pos.IsValid() &&
+			pkg.Info.Uses[ident] == obj {
+			pos = ident.Pos()
+		}
+		return !pos.IsValid()
+	})
+	return pos
+}
+
+// methodUses replaces the uses of a function in a declaration by the
+// method value name.function. It reports if the declaration changed.
+func (pkg *Package) methodUses(d *ast.FuncDecl, obj types.Object,
+	name string) bool {
+	changed := false
+	astutil.Apply(d.Body, func(c *astutil.Cursor) bool {
+		ident, ok := c.Node().(*ast.Ident)
+		if !ok || pkg.Info.Uses[ident] != obj {
+			return true
+		}
+		c.Replace(&ast.SelectorExpr{
+			X:   ast.NewIdent(name),
+			Sel: ast.NewIdent(ident.Name),
+		})
+		changed = true
+		return true
+	}, nil)
+	return changed
+}
+
+// lookupObject returns the object of a package level identifier or of
+// a field or method ("Type.Name").
+func (pkg *Package) lookupObject(name string) types.Object {
+	scope := pkg.Types.Scope()
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return scope.Lookup(name)
+	}
+	tn, ok := scope.Lookup(name[:i]).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	obj, _, _ := types.LookupFieldOrMethod(tn.Type(), true, pkg.Types,
+		name[i+1:])
+	return obj
+}
+
+// rename renames an identifier and all its uses.
+func (pkg *Package) rename(op Op) (bool, error) {
+	obj := pkg.lookupObject(op.Name)
+	if obj == nil {
+		to := op.To
+		if i := strings.Index(op.Name, "."); i >= 0 {
+			to = op.Name[:i+1] + to
+		}
+		if pkg.lookupObject(to) != nil {
+			return false, nil // already renamed
+		}
+		return false, fmt.Errorf("identifier %q not found", op.Name)
+	}
+	if e, err := parser.ParseExpr(op.To); err != nil || !isIdent(e) {
+		return false, fmt.Errorf("invalid identifier %q", op.To)
+	}
+	for _, f := range pkg.Ast.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			ident, ok := n.(*ast.Ident)
+			if !ok || ident.Name != obj.Name() {
+				return true
+			}
+			if pkg.Info.Defs[ident] == obj || pkg.Info.Uses[ident] == obj {
+				ident.Name = op.To
+				pkg.changed[f] = struct{}{}
+			}
+			return true
+		})
+	}
+	return true, nil
+}
+
+// isIdent checks if an expression is an identifier.
+func isIdent(e ast.Expr) bool {
+	_, ok := e.(*ast.Ident)
+	return ok
+}
+
+// insert inserts the statements of op.Code into a function body.
+func (pkg *Package) insert(op Op) (bool, error) {
+	fn, f := pkg.lookupDecl(op.Name)
+	if fn == nil || fn.Body == nil {
+		return false, fmt.Errorf("function %q not found", op.Name)
+	}
+	fset := token.NewFileSet()
+	code, err := parser.ParseFile(fset, "",
+		"package p\n\nfunc _() {\n"+op.Code+"\n}", 0)
+	if err != nil {
+		return false, err
+	}
+	stmts := code.Decls[0].(*ast.FuncDecl).Body.List
+	list := fn.Body.List
+	at := 0
+	switch op.At {
+	case "", "start":
+	case "end":
+		at = len(list)
+		if at > 0 {
+			if _, ok := list[at-1].(*ast.ReturnStmt); ok {
+				at--
+			}
+		}
+	default:
+		return false, fmt.Errorf("unknown position %q", op.At)
+	}
+	if at+len(stmts) <= len(list) &&
+		pkg.sameStmts(list[at:at+len(stmts)], fset, stmts) {
+		return false, nil // already inserted
+	}
+	for _, stmt := range stmts {
+		clearPos(stmt)
+	}
+	fn.Body.List = append(list[:at:at], append(stmts, list[at:]...)...)
+	pkg.changed[f] = struct{}{}
+	return true, nil
+}
+
+// sameStmts checks if the statements of the package print the same as
+// the statements b, which were parsed in fset.
+func (pkg *Package) sameStmts(a []ast.Stmt, fset *token.FileSet,
+	b []ast.Stmt) bool {
+	for i := range a {
+		x, errX := str(pkg.Fset, a[i])
+		y, errY := str(fset, b[i])
+		if errX != nil || errY != nil || x != y {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package packages
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"strings"
+)
+
+// Recheck type checks all changed packages again. See
+// Package.Recheck. If the package under test changed, the external
+// test package is checked again as well.
+func (pkgs *Packages) Recheck() error {
+	tested := false
+	for i := range *pkgs {
+		pkg := &(*pkgs)[i]
+		if !isExternalTest(pkg.Name) && (len(pkg.changed) > 0 || pkg.added) {
+			tested = true
+		}
+		if err := pkg.Recheck(); err != nil {
+			return err
+		}
+	}
+	if tested {
+		pkgs.checkTests()
+	}
+	return nil
+}
+
+// Recheck reprints the files, which are changed in memory (eg by
+// Fix), parses them again in the same file set and type checks the
+// package. This gives valid positions and type information without
+// saving files to disk. Added snippets are included as the
+// "snippets.go" file.
+func (pkg *Package) Recheck() error {
+	if len(pkg.changed) == 0 && !pkg.added {
+		return nil
+	}
+	for f := range pkg.changed {
+		filename := Filename(pkg.Fset, f)
+		var buf bytes.Buffer
+		if err := format.Node(&buf, pkg.Fset, f); err != nil {
+			return err
+		}
+		if err := pkg.reparse(filename, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if pkg.added {
+		if err := pkg.reparse(pkg.snippetsFilename(),
+			pkg.snippetsSource()); err != nil {
+			return err
+		}
+	}
+	pkg.changed = map[*ast.File]struct{}{}
+	pkg.added = false
+	pkg.check(pkg.Path())
+	return nil
+}
+
+// reparse parses the source of a file and replaces it in the package.
+func (pkg *Package) reparse(filename string, src []byte) error {
+	f, err := parser.ParseFile(pkg.Fset, filename, src,
+		parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	pkg.Ast.Files[filename] = f
+	return nil
+}
+
+// maxFixes limits the number of Fix iterations of FixAll.
+const maxFixes = 16
+
+// FixAll fixes type conflicts in all packages in memory, until no
+// conflicts are left or the conflicts do not change anymore. A
+// conflict which can not be fixed does not stop the iterations, as
+// it may disappear by other fixes. It returns the number of fixed
+// conflicts (of all iterations) and the remaining conflicts, which
+// could not be fixed. (These are the errors of the last attempt to
+// fix them, if any.)
+func (pkgs *Packages) FixAll(types TypeMap, logConflicts bool) (int,
+	[]error, error) {
+	count := 0
+	var prev string
+	var unfixed []error
+	for i := 0; i < maxFixes; i++ {
+		var msgs bytes.Buffer
+		for _, pkg := range *pkgs {
+			for _, err := range pkg.Errors {
+				msgs.WriteString(err.Error() + "\n")
+			}
+		}
+		if msgs.Len() == 0 || msgs.String() == prev {
+			break
+		}
+		prev = msgs.String()
+		n, errs, err := pkgs.Fix(types, logConflicts)
+		count += n
+		if err != nil {
+			return count, nil, err
+		}
+		unfixed = errs
+		if err := pkgs.Recheck(); err != nil {
+			return count, nil, err
+		}
+	}
+	var remaining []error
+	for _, pkg := range *pkgs {
+		for _, err := range pkg.Errors {
+			remaining = append(remaining, fixError(err, unfixed))
+		}
+	}
+	return count, remaining, nil
+}
+
+// fixError returns the error of a failed fix of a conflict (which ends
+// with the conflict) or else the conflict itself.
+func fixError(confl error, unfixed []error) error {
+	for _, err := range unfixed {
+		if strings.HasSuffix(err.Error(), confl.Error()) {
+			return err
+		}
+	}
+	return confl
+}
@@ -0,0 +1,339 @@
+package packages
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/types"
+)
+
+// Rounding policies for conversions from floats to integers.
+const (
+	Truncate = "truncate"
+	Round    = "round"
+	Floor    = "floor"
+)
+
+// roundFunc maps a rounding policy to its math function.
+var roundFunc = map[string]string{
+	Round: "Round",
+	Floor: "Floor",
+}
+
+// mathIdentity lists math functions which do not change an integer,
+// so they can be removed in a reverse conversion.
+var mathIdentity = newSet([]string{"Ceil", "Floor", "Round",
+	"RoundToEven", "Trunc"})
+
+type (
+	// Reverse configures a reverse conversion from floats to integers
+	// or to a scaled fixed-point type (eg for targets without FPU).
+	Reverse struct {
+		Rounding string // Truncate (default), Round or Floor
+		Scale    int64  // fixed-point scale, eg 1000 (default 1)
+	}
+	// Lossy describes a site where a reverse conversion loses
+	// precision. These sites should be reviewed.
+	Lossy struct {
+		Position token.Position
+		Source   string
+		Reason   string
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (l Lossy) String() string {
+	return fmt.Sprintf("%s: %s (%s)", l.Position, l.Source, l.Reason)
+}
+
+// isReverse checks if a conversion is from floats to integers (or
+// to a fixed-point type, which is not a builtin type).
+func isReverse(fromType, toType string) bool {
+	return kind[fromType] == token.FLOAT && kind[toType] != token.FLOAT
+}
+
+// scale returns the fixed-point scale (at least 1).
+func (r Reverse) scale() int64 {
+	if r.Scale < 1 {
+		return 1
+	}
+	return r.Scale
+}
+
+// quantize scales and rounds a float constant according to the
+// rounding policy. It returns false if precision is lost.
+func (r Reverse) quantize(f float64) (int64, bool) {
+	f *= float64(r.scale())
+	var q float64
+	switch r.Rounding {
+	case Round:
+		q = math.Round(f) // the same as convertFrom
+	case Floor:
+		q = math.Floor(f)
+	default:
+		q = math.Trunc(f)
+	}
+	return int64(q), q == f
+}
+
+// SetReverse sets the rounding policy and scale of a reverse
+// conversion for all packages.
+func (pkgs *Packages) SetReverse(r Reverse) {
+	for i := range *pkgs {
+		(*pkgs)[i].reverse = r
+	}
+}
+
+// Lossy returns the lossy sites of all packages, which were found
+// during Convert and Fix.
+func (pkgs *Packages) Lossy() []Lossy {
+	var lossy []Lossy
+	for _, pkg := range *pkgs {
+		lossy = append(lossy, pkg.lossy...)
+	}
+	return lossy
+}
+
+// addLossy reports a lossy site.
+func (pkg *Package) addLossy(node ast.Node, format string,
+	a ...interface{}) {
+	source, _ := str(pkg.Fset, node)
+	pkg.lossy = append(pkg.lossy, Lossy{
+		Position: pkg.Fset.Position(node.Pos()),
+		Source:   source,
+		Reason:   fmt.Sprintf(format, a...),
+	})
+}
+
+// quantizeLit quantizes a literal in a reverse conversion, for
+// example "2.5" -> "2" or with scale 1000 "2.5" -> "2500".
+func (pkg *Package) quantizeLit(lit *ast.BasicLit) {
+	f, err := strconv.ParseFloat(lit.Value, 64)
+	if err != nil {
+		return
+	}
+	q, ok := pkg.reverse.quantize(f)
+	if !ok {
+		pkg.addLossy(lit, "quantized to %d", q)
+	}
+	lit.Kind = token.INT
+	lit.Value = strconv.FormatInt(q, 10)
+}
+
+// scaled checks if e has a source type, which is converted to a
+// fixed-point type with a scale.
+func (c *convertor) scaled(e ast.Expr) bool {
+	if c.pkg.reverse.scale() == 1 {
+		return false
+	}
+	typ := c.pkg.Info.TypeOf(e)
+	if typ == nil {
+		return false
+	}
+	from := typ.Underlying().String()
+	to, ok := c.types[from]
+	return ok && isReverse(from, to)
+}
+
+// quantizeLit quantizes a literal only once, as it may be reached
+// both by convertBasicLit and by the walk of a fixed-point expression.
+// (Constants are also scaled only once, see scaleConst.)
+func (c *convertor) quantizeLit(lit *ast.BasicLit) {
+	if _, ok := c.quantized[lit]; ok {
+		return
+	}
+	c.quantized[lit] = struct{}{}
+	c.pkg.quantizeLit(lit)
+}
+
+// scaleConst scales an operand of a fixed-point expression, which is
+// an untyped integer constant, eg "x + c" -> "x + (c * 1000)".
+// (Literals are quantized when they are visited, typed constants by
+// their declaration.)
+func (c *convertor) scaleConst(e ast.Expr) ast.Expr {
+	var id *ast.Ident
+	switch x := e.(type) {
+	case *ast.Ident:
+		id = x
+	case *ast.SelectorExpr:
+		id = x.Sel
+	default:
+		return e
+	}
+	obj, ok := c.pkg.Info.Uses[id].(*types.Const)
+	if !ok || !c.scaled(e) {
+		return e
+	}
+	if _, ok := c.quantized[e]; ok {
+		return e
+	}
+	c.quantized[e] = struct{}{}
+	// untyped float constants are quantized by their declaration or
+	// by fixQuantize
+	if basic, ok := obj.Type().(*types.Basic); !ok ||
+		(basic.Kind() != types.UntypedInt &&
+			basic.Kind() != types.UntypedRune) {
+		return e
+	}
+	return &ast.ParenExpr{X: &ast.BinaryExpr{X: e, Op: token.MUL,
+		Y: scaleLit(c.pkg.reverse.scale())}}
+}
+
+// binaryExpr scales the constant operands of a fixed-point expression
+// and rescales products and quotients, which are reported as lossy:
+// "x * y" -> "x * y / 1000" and "x / y" -> "x * 1000 / y".
+func (c *convertor) binaryExpr(n *ast.BinaryExpr) {
+	if !c.scaled(n.X) && !c.scaled(n.Y) {
+		return
+	}
+	rescaled := (n.Op == token.MUL || n.Op == token.QUO) && c.scaled(n)
+	if rescaled {
+		c.pkg.addLossy(n, "fixed-point %s rescaled", n.Op)
+	}
+	n.X, n.Y = c.scaleConst(n.X), c.scaleConst(n.Y)
+	if rescaled {
+		rescale(n, c.pkg.reverse.scale())
+	}
+}
+
+// rescale rescales a product or quotient of fixed-point values in
+// place (see binaryExpr).
+func rescale(n *ast.BinaryExpr, scale int64) {
+	if n.Op == token.MUL {
+		n.X = &ast.BinaryExpr{X: n.X, Op: token.MUL, Y: n.Y}
+		n.Op, n.Y = token.QUO, scaleLit(scale)
+		return
+	}
+	n.X = &ast.BinaryExpr{X: n.X, Op: token.MUL, Y: scaleLit(scale)}
+}
+
+// rescaleAssign rescales "x *= y" to "x = x * y / 1000" and "x /= y"
+// to "x = x * 1000 / y" for fixed-point values. Other operands than
+// identifiers are reported as lossy without rescaling.
+func (c *convertor) rescaleAssign(as *ast.AssignStmt) {
+	if len(as.Lhs) != 1 || len(as.Rhs) != 1 ||
+		(as.Tok != token.MUL_ASSIGN && as.Tok != token.QUO_ASSIGN) ||
+		!c.scaled(as.Lhs[0]) {
+		return
+	}
+	id, ok := as.Lhs[0].(*ast.Ident)
+	if !ok {
+		c.pkg.addLossy(as, "fixed-point %s not rescaled", as.Tok)
+		return
+	}
+	c.pkg.addLossy(as, "fixed-point %s rescaled", as.Tok)
+	op := token.MUL
+	if as.Tok == token.QUO_ASSIGN {
+		op = token.QUO
+	}
+	y := c.scaleConst(as.Rhs[0])
+	if _, ok := y.(*ast.BinaryExpr); ok {
+		y = &ast.ParenExpr{X: y}
+	}
+	n := &ast.BinaryExpr{X: &ast.Ident{Name: id.Name}, Op: op, Y: y}
+	rescale(n, c.pkg.reverse.scale())
+	as.Tok = token.ASSIGN
+	as.Rhs[0] = n
+}
+
+// scaleLit returns the literal of a fixed-point scale.
+func scaleLit(scale int64) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.INT,
+		Value: strconv.FormatInt(scale, 10)}
+}
+
+// convertFrom converts e from type from to type to. In a reverse
+// conversion from floats, the value is scaled and rounded and the
+// site is reported as lossy. Values of toType are unscaled when they
+// are converted to floats.
+func (pkg *Package) convertFrom(f *ast.File, e ast.Expr, from, to,
+	toType string) ast.Expr {
+	scale := pkg.reverse.scale()
+	switch {
+	case kind[from] == token.FLOAT && kind[to] != token.FLOAT:
+		pkg.addLossy(e, "%s converted to %s", from, to)
+		if scale > 1 && to == toType {
+			e = &ast.BinaryExpr{
+				X:  e,
+				Op: token.MUL,
+				Y:  scaleLit(scale),
+			}
+		}
+		if name, ok := roundFunc[pkg.reverse.Rounding]; ok {
+			astutil.AddImport(pkg.Fset, f, "math")
+			e = callSelector("math", name, []ast.Expr{e})
+		}
+	case scale > 1 && from == toType && kind[to] == token.FLOAT:
+		return &ast.BinaryExpr{
+			X:  convert(e, pkg.typeName(to)),
+			Op: token.QUO,
+			Y:  scaleLit(scale),
+		}
+	}
+	return convert(e, pkg.typeName(to))
+}
+
+// fixMath removes math functions, which do not change integers
+// (eg math.Floor(i) -> i), if an integer is passed to them. With a
+// fixed-point scale they are kept, as the argument is rescaled to a
+// float by convertFrom (eg math.Floor(float64(i) / 1000)).
+func (pkg *Package) fixMath(confl conflict, from string) bool {
+	if kind[from] != token.INT || len(confl.path) < 3 ||
+		pkg.reverse.scale() > 1 {
+		return false
+	}
+	call, ok := confl.path[1].(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "math" {
+		return false
+	}
+	if _, ok := mathIdentity[sel.Sel.Name]; !ok {
+		return false
+	}
+	return replaceExpr(confl.path[2], call, call.Args[0])
+}
+
+// outerConst returns the index of the outermost constant expression
+// at the start of a path or -1 if there is none.
+func (pkg *Package) outerConst(path []ast.Node) int {
+	i := -1
+	for j, node := range path {
+		e, ok := node.(ast.Expr)
+		if !ok || pkg.Info.Types[e].Value == nil {
+			break
+		}
+		i = j
+	}
+	return i
+}
+
+// fixQuantize fixes float constants, which are truncated to an
+// integer type in a reverse conversion, by quantizing them.
+func fixQuantize(pkg *Package, confl conflict, fromType, toType string,
+	matches []string) error {
+	i := pkg.outerConst(confl.path)
+	if i < 0 || i+1 >= len(confl.path) {
+		return fmt.Errorf("fixQuantize expects constant: %s", confl.err)
+	}
+	e := confl.path[i].(ast.Expr)
+	f, _ := exact.Float64Val(pkg.Info.Types[e].Value)
+	q, _ := pkg.reverse.quantize(f)
+	pkg.addLossy(e, "quantized to %d", q)
+	lit := &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(q, 10)}
+	if !replaceExpr(confl.path[i+1], e, lit) {
+		return fmt.Errorf("fixQuantize can not replace constant: %s",
+			confl.err)
+	}
+	return nil
+}
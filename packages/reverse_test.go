@@ -0,0 +1,82 @@
+package packages
+
+import "testing"
+
+func TestReverse(t *testing.T) {
+	src := `package p
+
+import "math"
+
+func Whole(d float64) float64 {
+	return math.Round(d)
+}
+
+func Count(d float64) int {
+	return int(math.Floor(d))
+}
+
+func Sum(xs []float64) float64 {
+	s := 0.0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+`
+	tests := []struct {
+		reverse Reverse
+		want    string
+	}{
+		{Reverse{Rounding: Round, Scale: 1000}, `package p
+
+import "math"
+
+func Whole(d int64) int64 {
+	return int64(math.Round(math.Round(float64(d)/1000) * 1000))
+}
+
+func Count(d int64) int {
+	return int(math.Floor(float64(d) / 1000))
+}
+
+func Sum(xs []int64) int64 {
+	s := int64(0)
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+`},
+		// math.Round(d) has two conflicts: the argument and the result
+		{Reverse{}, `package p
+
+import "math"
+
+func Whole(d int64) int64 {
+	return d
+}
+
+func Count(d int64) int {
+	return int(d)
+}
+
+func Sum(xs []int64) int64 {
+	s := int64(0)
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+`},
+	}
+	types := TypeMap{"float64": "int64"}
+	for _, test := range tests {
+		pkgs, cleanup := convertSource(t, src, types,
+			func(pkgs *Packages) { pkgs.SetReverse(test.reverse) })
+		if got := fixSource(t, pkgs, types); got != test.want {
+			t.Errorf("%+v: got\n%s\nwant\n%s", test.reverse, got,
+				test.want)
+		}
+		cleanup()
+	}
+}
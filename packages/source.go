@@ -0,0 +1,56 @@
+package packages
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+)
+
+// source returns the source code of a file of the package. Only the
+// lines changed in the syntax tree differ from the original source,
+// so code which is not touched keeps its original layout. Files
+// without original source (eg snippets) are reprinted.
+func (pkg *Package) source(filename string, f *ast.File) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, pkg.Fset, f); err != nil {
+		return nil, err
+	}
+	orig, ok := pkg.sources[filename]
+	if !ok {
+		return buf.Bytes(), nil
+	}
+	return minimalSource(orig, buf.Bytes()), nil
+}
+
+// minimalSource applies the changes of a reprinted file as line edits
+// to its original source. The original source is formatted first:
+// changes which are only caused by formatting are discarded, changes
+// of the printed source are applied. If the lines of both overlap,
+// the printed lines win. If the result does not format to the
+// printed source, the printed source is returned.
+func minimalSource(orig, printed []byte) []byte {
+	formatted, err := format.Source(orig)
+	if err != nil {
+		return printed
+	}
+	want, err := format.Source(printed)
+	if err != nil {
+		return printed
+	}
+	var buf bytes.Buffer
+	for _, chunk := range merge3(splitLines(string(formatted)),
+		splitLines(string(orig)), splitLines(string(printed))) {
+		lines := chunk.ours
+		if chunk.conflict {
+			lines = chunk.theirs
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+		}
+	}
+	if got, err := format.Source(buf.Bytes()); err != nil ||
+		!bytes.Equal(got, want) {
+		return printed
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,105 @@
+package packages
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMinimalSource(t *testing.T) {
+	orig := `package p
+
+var table = map[string]int{
+	"a":   1,
+	"bbb":     2, // manual alignment
+}
+
+func  untouched( x int )  int { return x*2 }
+
+func f(x int) int {
+	y := x*3
+	return y
+}
+`
+	tests := []struct {
+		name    string
+		orig    string // if not the default orig
+		printed string // the reprinted (gofmt) source after a change
+		want    string
+	}{
+		{name: "unchanged", printed: `package p
+
+var table = map[string]int{
+	"a":   1,
+	"bbb": 2, // manual alignment
+}
+
+func untouched(x int) int { return x * 2 }
+
+func f(x int) int {
+	y := x * 3
+	return y
+}
+`, want: orig},
+		{name: "changed line", printed: `package p
+
+var table = map[string]int{
+	"a":   1,
+	"bbb": 2, // manual alignment
+}
+
+func untouched(x int) int { return x * 2 }
+
+func f(x float64) float64 {
+	y := x * 3
+	return y
+}
+`, want: strings.Replace(orig, "func f(x int) int {",
+			"func f(x float64) float64 {", 1)},
+		{name: "changed formatted line", printed: `package p
+
+var table = map[string]int{
+	"a":   1,
+	"bbb": 2, // manual alignment
+}
+
+func untouched(x int) int { return x * 2 }
+
+func f(x int) int {
+	y := x * 4
+	return y
+}
+`, want: strings.Replace(orig, "y := x*3", "y := x * 4", 1)},
+		{name: "inserted line", printed: `package p
+
+import "fmt"
+
+var table = map[string]int{
+	"a":   1,
+	"bbb": 2, // manual alignment
+}
+
+func untouched(x int) int { return x * 2 }
+
+func f(x int) int {
+	y := x * 3
+	fmt.Println(y)
+	return y
+}
+`, // the printed line before the insertion wins (overlap)
+			want: strings.Replace(strings.Replace(orig, "package p\n",
+				"package p\n\nimport \"fmt\"\n", 1), "\ty := x*3\n",
+				"\ty := x * 3\n\tfmt.Println(y)\n", 1)},
+		{name: "invalid original", orig: "package p\nfunc {\n",
+			printed: "package p\n", want: "package p\n"},
+	}
+	for _, test := range tests {
+		src := orig
+		if test.orig != "" {
+			src = test.orig
+		}
+		got := string(minimalSource([]byte(src), []byte(test.printed)))
+		if got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
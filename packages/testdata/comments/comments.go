@@ -0,0 +1,62 @@
+// Package comments is a corpus of comments, which must survive a
+// conversion by gofloat. TestLostComments converts it and checks
+// that no comments are lost or moved.
+package comments
+
+// Point is a point.
+type Point struct {
+	// X and Y are the coordinates.
+	X, Y int // in pixels
+	// a, i and b share a line comment.
+	a, i, b int // mixed
+	N       int // trailing
+	Tag     int `json:"tag"` // tagged
+}
+
+// Size is a size.
+type Size [2]int // width and height
+
+// scale scales a point.
+func scale(p Point, f float64) Point {
+	// scale both coordinates
+	x := float64(p.X) * f // scaled x
+	/* keep y */
+	y := p.Y
+	var s []int
+	_ = s[len(s)-1 /* last */]    // index
+	return Point{X: int(x), Y: y} // done
+}
+
+// Max is the maximum.
+const Max = 10 // limit
+
+// h has mixed parameters.
+func h(a, // first (i is skipped, so the field is split)
+	i, // skipped
+	b int, // last
+	c int /* c */) (r int /* result */) {
+	return a + b + c
+}
+
+func g(x float64) {}
+
+// k calls g.
+func k(s []int, p Point) {
+	g(float64(p.X)) // converted argument
+	g(
+		// doc of the argument
+		float64(p.Y), // trailing of the argument
+	)
+	_ = s[
+	// index doc
+	p.X] // index
+	q := []int{
+		p.X, // one
+		// two
+		p.Y,
+	}
+	_ = q
+	if p.X < /* limit */ len(s) { // compare
+		return
+	}
+}
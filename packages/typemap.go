@@ -0,0 +1,61 @@
+package packages
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TypeMap maps source types to destination types, for example
+// {"int": "float64", "int32": "float32"}. All types are converted
+// simultaneously, each with its own rules.
+type TypeMap map[string]string
+
+// From returns the sorted source types, so rules are applied in a
+// deterministic order.
+func (tm TypeMap) From() []string {
+	from := make([]string, 0, len(tm))
+	for typ := range tm {
+		from = append(from, typ)
+	}
+	sort.Strings(from)
+	return from
+}
+
+// String implements the fmt.Stringer interface.
+// (For example "int -> float64, int32 -> float32".)
+func (tm TypeMap) String() string {
+	rules := make([]string, 0, len(tm))
+	for _, from := range tm.From() {
+		rules = append(rules, fmt.Sprintf("%s -> %s", from, tm[from]))
+	}
+	return strings.Join(rules, ", ")
+}
+
+// match returns the rule which applies to the type names of a type
+// error. A rule with both its types in names is preferred to a rule
+// with only its destination or source type. Otherwise the first rule
+// is returned.
+func (tm TypeMap) match(names []string) (string, string) {
+	found := newSet(names)
+	has := func(typ string) bool {
+		_, ok := found[typ]
+		return ok
+	}
+	from := tm.From()
+	if len(from) == 0 {
+		return "", ""
+	}
+	for _, cond := range []func(f string) bool{
+		func(f string) bool { return has(f) && has(tm[f]) },
+		func(f string) bool { return has(tm[f]) },
+		has,
+	} {
+		for _, f := range from {
+			if cond(f) {
+				return f, tm[f]
+			}
+		}
+	}
+	return from[0], tm[from[0]]
+}
@@ -5,6 +5,7 @@ import (
 	"go/ast"
 	"go/format"
 	"go/token"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +16,26 @@ import (
 // goPathSrc is the source gopath based on environment variable.
 var goPathSrc = filepath.Join(os.Getenv("GOPATH"), "src")
 
+// Output receives the messages of the packages, such as the type
+// conflicts found by Fix, unless they have their own (see SetOutput).
+var Output io.Writer = os.Stdout
+
+// SetOutput makes all packages write their messages to w instead of
+// Output, for example to buffer them.
+func (pkgs *Packages) SetOutput(w io.Writer) {
+	for i := range *pkgs {
+		(*pkgs)[i].output = w
+	}
+}
+
+// out returns the writer for the messages of the package.
+func (pkg *Package) out() io.Writer {
+	if pkg.output != nil {
+		return pkg.output
+	}
+	return Output
+}
+
 // base returns the last element of path without the extension.
 func base(path string) string {
 	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
@@ -24,7 +45,7 @@ func base(path string) string {
 // ast.Ident
 func callIdent(name string, args []ast.Expr) ast.Expr {
 	return &ast.CallExpr{
-		Fun:  &ast.Ident{Name: name},
+		Fun:  &ast.Ident{NamePos: argsPos(args), Name: name},
 		Args: args,
 	}
 }
@@ -34,23 +55,59 @@ func callIdent(name string, args []ast.Expr) ast.Expr {
 func callSelector(x, sel string, args []ast.Expr) ast.Expr {
 	return &ast.CallExpr{
 		Fun: &ast.SelectorExpr{
-			X:   &ast.Ident{Name: x},
+			X:   &ast.Ident{NamePos: argsPos(args), Name: x},
 			Sel: &ast.Ident{Name: sel}},
 		Args: args,
 	}
 }
 
+// argsPos returns the position of the first argument, so that
+// comments before the arguments are not moved inside a new call.
+func argsPos(args []ast.Expr) token.Pos {
+	if len(args) == 0 {
+		return token.NoPos
+	}
+	return args[0].Pos()
+}
+
 // convert an expression to another type, for example e -> int(e)
 func convert(e ast.Expr, toType string) ast.Expr {
 	if e == nil {
 		return e
 	}
 	return &ast.CallExpr{
-		Fun:  &ast.Ident{Name: toType},
+		Fun:  typeExpr(e.Pos(), toType),
 		Args: []ast.Expr{astutil.Unparen(e)},
 	}
 }
 
+// typeExpr returns a type name as the function of a conversion. A
+// pointer type is parenthesized, as *T(x) would dereference T(x).
+func typeExpr(pos token.Pos, name string) ast.Expr {
+	ident := &ast.Ident{NamePos: pos, Name: name}
+	if strings.HasPrefix(name, "*") {
+		return &ast.ParenExpr{Lparen: pos, X: ident}
+	}
+	return ident
+}
+
+// replaceExpr replaces a direct child expression of parent.
+// It returns false if old is not a child of parent.
+func replaceExpr(parent ast.Node, old, new ast.Expr) bool {
+	replaced := false
+	astutil.Apply(parent, func(c *astutil.Cursor) bool {
+		if c.Node() == parent {
+			return true
+		}
+		if c.Node() == old {
+			c.Replace(new)
+			replaced = true
+		}
+		return false
+	}, nil)
+	return replaced
+}
+
 // Filename of an ast.Node.
 func Filename(fset *token.FileSet, node ast.Node) string {
 	return fset.Position(node.Pos()).Filename